@@ -138,3 +138,37 @@ func TestGetPollers(t *testing.T) {
 		t.Fatalf("expected no poller after deleting one poller, got %v", len(plrs))
 	}
 }
+
+func TestGetPoller(t *testing.T) {
+	pool := NewPool()
+
+	go func() {
+		_ = pool.Run()
+	}()
+
+	plr := &testPoller{
+		ch: make(chan struct{}),
+	}
+
+	plr.pollfn = func(ctx context.Context) error {
+		<-ctx.Done()
+		plr.ch <- struct{}{}
+
+		return nil
+	}
+
+	pool.AddPoller("test", plr)
+
+	got, ok := pool.GetPoller("test")
+	if !ok || got != plr {
+		t.Fatalf("expected to get the added poller, got %v", got)
+	}
+
+	pool.DeletePoller("test")
+	<-plr.ch
+
+	_, ok = pool.GetPoller("test")
+	if ok {
+		t.Fatal("expected no poller after deleting it")
+	}
+}
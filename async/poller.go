@@ -21,6 +21,11 @@ type msgAddProc struct {
 	plr Poller
 }
 
+type msgGetProc struct {
+	key  string
+	resp chan Poller
+}
+
 // Pool is a group of running Pollers.
 type Pool struct {
 	db map[string]proc
@@ -29,6 +34,7 @@ type Pool struct {
 	addChan chan msgAddProc
 	rmChan  chan string
 	getChan chan struct{}
+	oneChan chan msgGetProc
 
 	// This is for output of keys coming from the pool.
 	// Every get request gets its own channel to recieve
@@ -46,6 +52,7 @@ func NewPool() *Pool {
 		addChan: make(chan msgAddProc),
 		rmChan:  make(chan string),
 		getChan: make(chan struct{}),
+		oneChan: make(chan msgGetProc),
 
 		outChan: make(chan chan string),
 	}
@@ -104,6 +111,11 @@ func (pool *Pool) Run() error {
 			}
 
 			close(respch)
+
+		case getmsg := <-pool.oneChan:
+			// The response channel is buffered so that this loop never
+			// blocks on a slow reader.
+			getmsg.resp <- pool.db[getmsg.key].plr
 		}
 	}
 }
@@ -144,3 +156,18 @@ func (pool *Pool) GetPollers() []string {
 
 	return keys
 }
+
+// GetPoller returns the Poller with the given key, so callers can ask
+// it about its state. If no poller with the given key is present, it
+// returns false.
+func (pool *Pool) GetPoller(key string) (Poller, bool) {
+	msg := msgGetProc{
+		key:  key,
+		resp: make(chan Poller, 1),
+	}
+
+	pool.oneChan <- msg
+	plr := <-msg.resp
+
+	return plr, plr != nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// breakerClosed is the normal state, where every check goes ahead.
+	breakerClosed = "closed"
+	// breakerOpen means the remote kept failing, so the poller is
+	// degraded and only checks it again after the longest backoff.
+	breakerOpen = "open"
+	// breakerHalfOpen means the next check is a probe of whether the
+	// remote has recovered.
+	breakerHalfOpen = "half-open"
+	// breakerSuspended means the remote failed in a way that won't go
+	// away by itself, like a deleted repo. Suspended pollers only check
+	// the remote again after the longest backoff, to see if it's fixed.
+	breakerSuspended = "suspended"
+)
+
+// defaultBreakerThreshold and defaultMaxBackoff are used by breakers
+// that aren't configured.
+const (
+	defaultBreakerThreshold = 5
+	defaultMaxBackoff       = 30 * time.Minute
+)
+
+// breaker keeps track of a poller's consecutive failures. Every failure
+// doubles the wait before the next check, up to the maximum backoff.
+// Once there have been threshold failures in a row, the breaker opens,
+// and stays open until a probe of the remote succeeds. Failures that are
+// permanent suspend the poller instead, without waiting for the rest.
+type breaker struct {
+	threshold  int
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+}
+
+// withDefaults fills in the settings that aren't configured.
+func (b *breaker) withDefaults() {
+	if b.threshold <= 0 {
+		b.threshold = defaultBreakerThreshold
+	}
+
+	if b.maxBackoff <= 0 {
+		b.maxBackoff = defaultMaxBackoff
+	}
+
+	if b.state == "" {
+		b.state = breakerClosed
+	}
+}
+
+// attempt is called before each check. An open breaker half-opens,
+// making the check a probe.
+func (b *breaker) attempt() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.withDefaults()
+
+	if b.state == breakerOpen {
+		b.state = breakerHalfOpen
+	}
+}
+
+// success records a check that worked, closing the breaker. It returns
+// the lifecycle event to publish if that took the poller out of being
+// degraded or suspended.
+func (b *breaker) success() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.withDefaults()
+
+	event := ""
+	switch b.state {
+	case breakerOpen, breakerHalfOpen:
+		event = lifecycleRecovered
+	case breakerSuspended:
+		event = lifecycleResumed
+	}
+
+	b.state = breakerClosed
+	b.failures = 0
+
+	return event
+}
+
+// failure records a check that failed and returns how long to wait
+// before the next one, given the poller's usual interval. Permanent
+// failures suspend the poller straight away. It also returns the
+// lifecycle event to publish if the failure degraded or suspended the
+// poller.
+func (b *breaker) failure(interval time.Duration, permanent bool) (time.Duration, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.withDefaults()
+
+	b.failures++
+
+	event := ""
+	switch {
+	case b.state == breakerSuspended:
+		// Anything short of a successful check leaves it suspended.
+	case permanent:
+		b.state = breakerSuspended
+		event = lifecycleSuspended
+	case b.state == breakerHalfOpen:
+		// The probe failed, so the remote hasn't recovered yet.
+		b.state = breakerOpen
+	case b.state == breakerClosed && b.failures >= b.threshold:
+		b.state = breakerOpen
+		event = lifecycleDegraded
+	}
+
+	// Failing pollers never check more often than they usually would,
+	// even if their interval is longer than the maximum backoff.
+	limit := b.maxBackoff
+	if interval > limit {
+		limit = interval
+	}
+
+	if b.state != breakerClosed {
+		return limit, event
+	}
+
+	wait := interval
+	for i := 0; i < b.failures && wait < limit; i++ {
+		wait *= 2
+	}
+
+	if wait > limit {
+		wait = limit
+	}
+
+	return wait, event
+}
+
+// Breaker returns the state of the poller's circuit breaker and how many
+// checks in a row have failed.
+func (gp *gitPoller) Breaker() (string, int) {
+	return gp.breaker.status()
+}
+
+// status returns the state of the breaker and the number of failures
+// in a row.
+func (b *breaker) status() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.withDefaults()
+
+	return b.state, b.failures
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 3, maxBackoff: 5 * time.Minute}
+
+	if state, _ := b.status(); state != breakerClosed {
+		t.Fatalf("expected a new breaker to be closed, got %v", state)
+	}
+
+	// Every failure doubles the wait, up to the maximum backoff.
+	for i, expected := range []time.Duration{2 * time.Minute, 4 * time.Minute} {
+		b.attempt()
+		wait, event := b.failure(time.Minute, false)
+		if wait != expected || event != "" {
+			t.Fatalf("expected failure %v to wait %v without opening, got %v, %q", i+1, expected, wait, event)
+		}
+	}
+
+	b.attempt()
+	wait, event := b.failure(time.Minute, false)
+	if wait != 5*time.Minute || event != lifecycleDegraded {
+		t.Fatalf("expected the breaker to open and wait 5m, got %v, %q", wait, event)
+	}
+
+	if state, failures := b.status(); state != breakerOpen || failures != 3 {
+		t.Fatalf("expected an open breaker with 3 failures, got %v with %v", state, failures)
+	}
+
+	// The next check is a probe. If that fails, the breaker opens again
+	// without counting as newly degraded.
+	b.attempt()
+	if state, _ := b.status(); state != breakerHalfOpen {
+		t.Fatalf("expected the breaker to half-open, got %v", state)
+	}
+
+	wait, event = b.failure(time.Minute, false)
+	if wait != 5*time.Minute || event != "" {
+		t.Fatalf("expected a failed probe to wait 5m, got %v, %q", wait, event)
+	}
+
+	b.attempt()
+	if event := b.success(); event != lifecycleRecovered {
+		t.Fatalf("expected a successful probe to recover the poller, got %q", event)
+	}
+
+	if state, failures := b.status(); state != breakerClosed || failures != 0 {
+		t.Fatalf("expected a closed breaker without failures, got %v with %v", state, failures)
+	}
+
+	if event := b.success(); event != "" {
+		t.Fatalf("expected a closed breaker not to recover again, got %q", event)
+	}
+
+	// Backing off never checks more often than the usual interval.
+	b.attempt()
+	if wait, _ := b.failure(time.Hour, false); wait != time.Hour {
+		t.Fatalf("expected an hourly poller to wait an hour, got %v", wait)
+	}
+}
+
+func TestBreakerSuspend(t *testing.T) {
+	b := &breaker{threshold: 3, maxBackoff: 5 * time.Minute}
+
+	b.attempt()
+	wait, event := b.failure(time.Minute, true)
+	if wait != 5*time.Minute || event != lifecycleSuspended {
+		t.Fatalf("expected a permanent failure to suspend the poller, got %v, %q", wait, event)
+	}
+
+	// Suspended pollers stay suspended until a check works, whatever
+	// else goes wrong in the meantime.
+	for _, permanent := range []bool{true, false, false, false} {
+		b.attempt()
+		wait, event := b.failure(time.Minute, permanent)
+		if wait != 5*time.Minute || event != "" {
+			t.Fatalf("expected the poller to stay suspended, got %v, %q", wait, event)
+		}
+	}
+
+	if state, _ := b.status(); state != breakerSuspended {
+		t.Fatalf("expected a suspended breaker, got %v", state)
+	}
+
+	b.attempt()
+	if event := b.success(); event != lifecycleResumed {
+		t.Fatalf("expected the poller to resume, got %q", event)
+	}
+
+	// Degraded pollers are suspended too if the remote turns out to be
+	// gone for good.
+	for i := 0; i < 3; i++ {
+		b.attempt()
+		b.failure(time.Minute, false)
+	}
+
+	b.attempt()
+	if _, event := b.failure(time.Minute, true); event != lifecycleSuspended {
+		t.Fatalf("expected a degraded poller to be suspended, got %q", event)
+	}
+}
+
+func TestPollDegrades(t *testing.T) {
+	lifecycle := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote:   "http://127.0.0.1:1/repo.git",
+		tags:     "v*",
+		interval: time.Millisecond,
+
+		breaker: breaker{
+			threshold:  2,
+			maxBackoff: 10 * time.Millisecond,
+		},
+
+		lifecycle: lifecycle,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = gp.Poll(ctx)
+	}()
+
+	select {
+	case buf := <-lifecycle:
+		var ev lifecycleEvent
+		err := json.Unmarshal(buf, &ev)
+		if err != nil {
+			t.Fatalf("got error unmarshaling lifecycle event: %v", err)
+		}
+
+		if ev.Type != lifecycleDegraded || ev.Kind != string(errNetwork) || ev.Error == "" {
+			t.Fatalf("expected a degraded event with a network error, got %+v", ev)
+		}
+
+		// Tag pollers are named the way they're keyed in the pool.
+		key := pollermsg{Remote: gp.remote, Tags: "v*"}.key()
+		if ev.Remote+"#"+ev.Poller != key {
+			t.Fatalf("expected the event to come from %v, got %+v", key, ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poller to be degraded")
+	}
+
+	if state, _ := gp.Breaker(); state == breakerClosed {
+		t.Fatalf("expected the breaker not to be closed, got %v", state)
+	}
+}
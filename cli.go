@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/run-ci/git-poller/runlet"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const usage = `usage: git-poller [command]
+
+Without a command, git-poller runs the poller server.
+
+commands:
+  validate [flags] <dir>  check the pipelines in a local checkout or repo
+  schema                  print a JSON Schema of the pipeline format
+`
+
+// runCommand runs the subcommand in args and returns the exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "validate":
+		return runValidate(args[1:], stdout, stderr)
+	case "schema":
+		return runSchema(stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %v\n\n%v", args[0], usage)
+		return 2
+	}
+}
+
+// runValidate loads the pipelines in a directory the same way pollers
+// load them from a commit, and prints every problem found as
+// "file:line: message". It fails if there are any problems.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	var d discovery
+	var extensions, rev string
+	flags.StringVar(&d.Dir, "pipelines-dir", "", "directory pipelines are read from (default \"pipelines\")")
+	flags.StringVar(&extensions, "extensions", "", "comma-separated extensions of pipeline files (default \".yaml,.yml,.json\")")
+	flags.BoolVar(&d.Recursive, "recursive", false, "read pipelines from subdirectories too")
+	flags.StringVar(&rev, "rev", "", "check the pipelines in a commit of the repo in dir instead of the files in it")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprint(stderr, "usage: git-poller validate [flags] <dir>\n")
+		return 2
+	}
+	dir := flags.Arg(0)
+
+	if extensions != "" {
+		d.Extensions = strings.Split(extensions, ",")
+	}
+
+	err = d.validate()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	var pipelines []pipeline
+	var errs []pipelineError
+	if rev != "" {
+		var commit *object.Commit
+		commit, err = resolveCommit(dir, rev)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to find %v in %v: %v\n", rev, dir, err)
+			return 1
+		}
+
+		pipelines, errs, err = loadPipelinesAt(commit, d)
+	} else {
+		pipelines, errs, err = loadPipelines(dirSource(dir), d)
+	}
+	if os.IsNotExist(err) {
+		fmt.Fprintf(stderr, "%v has no %v directory\n", dir, d.withDefaults().Dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to list pipeline files: %v\n", err)
+		return 1
+	}
+
+	diags := []runlet.Diagnostic{}
+	for _, perr := range errs {
+		diags = append(diags, runlet.NewDiagnostic(perr.path, perr.err))
+	}
+
+	// Templates are expanded against a made-up commit, which is enough
+	// to catch anything that would fail against a real one.
+	for _, p := range pipelines {
+		ev := p.Event
+		ev.Remote = runlet.Remote{
+			URL:    dir,
+			Branch: "master",
+			Commit: runlet.Commit{SHA: strings.Repeat("0", 40)},
+		}
+
+		_, err := ev.ExpandArguments()
+		if err != nil {
+			d := runlet.NewDiagnostic(p.path, err)
+			d.Pipeline = p.Name
+			diags = append(diags, d)
+		}
+	}
+
+	for _, d := range diags {
+		if d.Line > 0 {
+			fmt.Fprintf(stdout, "%v:%v: %v\n", d.File, d.Line, d.Message)
+		} else {
+			fmt.Fprintf(stdout, "%v: %v\n", d.File, d.Message)
+		}
+	}
+
+	if len(diags) > 0 {
+		fmt.Fprintf(stderr, "found %v problems\n", len(diags))
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "%v pipelines ok\n", len(pipelines))
+	return 0
+}
+
+// runSchema prints the JSON Schema of pipeline files.
+func runSchema(stdout, stderr io.Writer) int {
+	buf, err := json.MarshalIndent(runlet.Schema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to marshal schema: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "%s\n", buf)
+	return 0
+}
+
+// resolveCommit finds the commit rev names in the repo at dir.
+func resolveCommit(dir, rev string) (*object.Commit, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.CommitObject(*hash)
+}
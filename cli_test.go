@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestRunValidate(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/test.yaml": testPipeline,
+		"pipelines/broken.yaml": `
+branch: master
+steps: []
+bogus: true
+`,
+		"pipelines/template.yaml": `
+steps:
+- name: build
+  tasks:
+  - name: image
+    arguments:
+      tag: "{{ .Commit.Nope }}"
+`,
+		"ci/test.yaml": testPipeline,
+	})
+	defer cleanup()
+	dir := string(src.(dirSource))
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"validate", dir}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected validate to fail, got exit code %v", code)
+	}
+
+	expected := "pipelines/broken.yaml:4: field bogus not found in type runlet.Event\n" +
+		"pipelines/template.yaml: step build, task image, argument tag: template: argument:1:10: executing \"argument\" at <.Commit.Nope>: can't evaluate field Nope in type runlet.Commit\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected output:\n%v\ngot:\n%v", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = runCommand([]string{"validate", "-pipelines-dir", "ci", dir}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected validate to pass, got exit code %v: %v", code, stdout.String())
+	}
+
+	code = runCommand([]string{"validate", "-pipelines-dir", "missing", dir}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected validate to fail without pipelines, got exit code %v", code)
+	}
+
+	code = runCommand([]string{"validate"}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("expected validate without a directory to be a usage error, got exit code %v", code)
+	}
+}
+
+func TestRunSchema(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"schema"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected schema to succeed, got exit code %v: %v", code, stderr.String())
+	}
+
+	var schema map[string]interface{}
+	err := json.Unmarshal(stdout.Bytes(), &schema)
+	if err != nil {
+		t.Fatalf("expected the schema to be JSON, got %v", err)
+	}
+
+	versions, ok := schema["oneOf"].([]interface{})
+	if !ok || len(versions) == 0 {
+		t.Fatalf("expected the schema to describe every version, got %v", schema)
+	}
+
+	for _, version := range versions {
+		if _, ok := version.(map[string]interface{})["properties"].(map[string]interface{})["steps"]; !ok {
+			t.Fatalf("expected the schema to describe steps, got %v", version)
+		}
+	}
+}
+
+func TestRunValidateRev(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	broken := tr.commit("broken pipeline", map[string]string{
+		"pipelines/test.yaml": testPipeline + "bogus: true\n",
+	})
+	tr.commit("fix pipeline", map[string]string{
+		"pipelines/test.yaml": testPipeline,
+	})
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"validate", "-rev", "master", tr.dir}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected the latest commit to pass, got exit code %v: %v", code, stdout.String())
+	}
+
+	code = runCommand([]string{"validate", "-rev", broken.String(), tr.dir}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected the broken commit to fail, got exit code %v", code)
+	}
+
+	code = runCommand([]string{"validate", "-rev", "nope", tr.dir}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected an unknown revision to fail, got exit code %v", code)
+	}
+}
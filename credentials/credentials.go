@@ -0,0 +1,166 @@
+// Package credentials loads the credentials pollers use to access
+// private remotes. Credentials are referred to by name, so that the
+// secrets themselves never have to be passed around in messages or
+// show up in logs.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var logger *logrus.Entry
+
+func init() {
+	logger = logrus.WithField("package", "credentials")
+}
+
+const (
+	// TypeSSH is a private key for SSH remotes.
+	TypeSSH = "ssh"
+	// TypeHTTPS is a username and token for HTTPS remotes.
+	TypeHTTPS = "https"
+)
+
+// ErrNotFound is returned when there's no credential with a given name.
+var ErrNotFound = errors.New("credential not found")
+
+// Credential is a single entry in a credentials file.
+type Credential struct {
+	Type string `yaml:"type"`
+
+	// User is the SSH user, which defaults to "git". The private key
+	// file may be encrypted with Passphrase. Host keys are checked
+	// against KnownHostsFile, or the default known_hosts files if
+	// that's not set.
+	User           string `yaml:"user"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	Passphrase     string `yaml:"passphrase"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+
+	// Username and Token are used for HTTP basic auth.
+	Username string `yaml:"username"`
+	Token    string `yaml:"token"`
+}
+
+// Store holds the auth methods for every credential by name. The zero
+// value is an empty Store.
+type Store struct {
+	auths map[string]transport.AuthMethod
+}
+
+// Load reads the credentials file at path. The file is a YAML map of
+// credential names to credentials:
+//
+//	deploy-key:
+//	  type: ssh
+//	  private_key_file: /secrets/id_rsa
+//	  known_hosts_file: /secrets/known_hosts
+//	gitlab:
+//	  type: https
+//	  username: oauth2
+//	  token: s3cr3t
+//
+// Every credential is checked up front so that mistakes show up at
+// startup instead of the first time a poller uses them.
+func Load(path string) (*Store, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := map[string]Credential{}
+	err = yaml.UnmarshalStrict(buf, &creds)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file %v: %v", path, redact(err))
+	}
+
+	store := &Store{
+		auths: make(map[string]transport.AuthMethod),
+	}
+
+	for name, cred := range creds {
+		logger.WithField("credential", name).Debug("loading credential")
+
+		auth, err := cred.authMethod()
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential %v: %v", name, err)
+		}
+
+		store.auths[name] = auth
+	}
+
+	return store, nil
+}
+
+// Get returns the auth method for the credential with the given name.
+func (s *Store) Get(name string) (transport.AuthMethod, error) {
+	auth, ok := s.auths[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return auth, nil
+}
+
+func (cred Credential) authMethod() (transport.AuthMethod, error) {
+	switch cred.Type {
+	case TypeSSH:
+		if cred.PrivateKeyFile == "" {
+			return nil, errors.New("ssh credentials need a private_key_file")
+		}
+
+		user := cred.User
+		if user == "" {
+			user = "git"
+		}
+
+		auth, err := ssh.NewPublicKeysFromFile(user, cred.PrivateKeyFile, cred.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load private key: %v", redact(err))
+		}
+
+		if cred.KnownHostsFile != "" {
+			auth.HostKeyCallback, err = ssh.NewKnownHostsCallback(cred.KnownHostsFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load known hosts: %v", err)
+			}
+		}
+
+		return auth, nil
+	case TypeHTTPS:
+		if cred.Token == "" {
+			return nil, errors.New("https credentials need a token")
+		}
+
+		// Most hosts ignore the username when a token is used, but it
+		// can't be empty.
+		username := cred.Username
+		if username == "" {
+			username = "git"
+		}
+
+		return &http.BasicAuth{
+			Username: username,
+			Password: cred.Token,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential type %q", cred.Type)
+	}
+}
+
+// quoted matches the values YAML errors quote, which could be secrets.
+var quoted = regexp.MustCompile("`[^`]*`")
+
+// redact strips quoted values out of an error message.
+func redact(err error) string {
+	return quoted.ReplaceAllString(err.Error(), "`<redacted>`")
+}
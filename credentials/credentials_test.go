@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("got error writing %v: %v", name, err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("got error generating key: %v", err)
+	}
+
+	keyfile := writeFile(t, dir, "id_rsa", string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})))
+	knownHosts := writeFile(t, dir, "known_hosts", "")
+
+	path := writeFile(t, dir, "credentials.yaml", fmt.Sprintf(`
+deploy-key:
+  type: ssh
+  private_key_file: %v
+  known_hosts_file: %v
+gitlab:
+  type: https
+  username: oauth2
+  token: s3cr3t
+`, keyfile, knownHosts))
+
+	store, err := Load(path)
+	if err != nil {
+		t.Fatalf("got error loading credentials: %v", err)
+	}
+
+	auth, err := store.Get("deploy-key")
+	if err != nil {
+		t.Fatalf("got error getting deploy-key: %v", err)
+	}
+
+	pk, ok := auth.(*ssh.PublicKeys)
+	if !ok {
+		t.Fatalf("expected deploy-key to be SSH public keys, got %T", auth)
+	}
+	if pk.User != "git" {
+		t.Fatalf("expected SSH user to default to git, got %v", pk.User)
+	}
+	if pk.HostKeyCallback == nil {
+		t.Fatal("expected host keys to be checked against known_hosts_file")
+	}
+
+	auth, err = store.Get("gitlab")
+	if err != nil {
+		t.Fatalf("got error getting gitlab: %v", err)
+	}
+
+	basic, ok := auth.(*http.BasicAuth)
+	if !ok {
+		t.Fatalf("expected gitlab to be basic auth, got %T", auth)
+	}
+	if basic.Username != "oauth2" || basic.Password != "s3cr3t" {
+		t.Fatalf("expected oauth2:s3cr3t, got %v:%v", basic.Username, basic.Password)
+	}
+	if strings.Contains(basic.String(), "s3cr3t") {
+		t.Fatal("expected token to be masked when printed")
+	}
+
+	if _, err := store.Get("nope"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for unknown credential, got %v", err)
+	}
+
+	var empty Store
+	if _, err := empty.Get("gitlab"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound from empty store, got %v", err)
+	}
+}
+
+func TestLoadDoesNotLeakSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test")
+	if err != nil {
+		t.Fatalf("got error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The token is where the credential should be, so YAML complains
+	// about it as a value.
+	path := writeFile(t, dir, "credentials.yaml", `
+gitlab: s3cr3t
+`)
+
+	_, err = Load(path)
+	if err == nil {
+		t.Fatal("expected error loading malformed credentials")
+	}
+
+	if strings.Contains(err.Error(), "s3cr3t") {
+		t.Fatalf("expected error not to contain the token, got %v", err)
+	}
+}
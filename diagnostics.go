@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/run-ci/git-poller/runlet"
+)
+
+// ref is the full name of the ref that changed.
+func (t trigger) ref() string {
+	if t.tag != "" {
+		return tagRefPrefix + t.tag
+	}
+
+	return branchRefPrefix + t.branch
+}
+
+// publishDiagnostics queues up the problems found with the pipelines
+// in the trigger's commit, or with the ref if it has no commit, if the
+// poller has somewhere to send them.
+// They're also kept as the latest diagnostics for the trigger's ref,
+// replacing whatever was found for the ref before.
+func (gp *gitPoller) publishDiagnostics(t trigger, diags []runlet.Diagnostic) {
+	for i := range diags {
+		diags[i].Remote = gp.remote
+		diags[i].Branch = t.branch
+		diags[i].Tag = t.tag
+		if t.commit != nil {
+			diags[i].Commit = t.commit.Hash.String()
+		}
+	}
+
+	gp.diagnosticsMu.Lock()
+	if gp.diagnostics == nil {
+		gp.diagnostics = map[string][]runlet.Diagnostic{}
+	}
+
+	if len(diags) == 0 {
+		delete(gp.diagnostics, t.ref())
+	} else {
+		gp.diagnostics[t.ref()] = diags
+	}
+	gp.diagnosticsMu.Unlock()
+
+	if gp.pipelineErrors == nil {
+		return
+	}
+
+	for _, d := range diags {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			logger.WithError(err).WithField("diagnostic", d).
+				Error("unable to marshal diagnostic")
+
+			continue
+		}
+
+		gp.pipelineErrors <- buf
+	}
+}
+
+// forgetDiagnostics drops the diagnostics kept for a ref that's gone.
+func (gp *gitPoller) forgetDiagnostics(ref string) {
+	gp.diagnosticsMu.Lock()
+	defer gp.diagnosticsMu.Unlock()
+
+	delete(gp.diagnostics, ref)
+}
+
+// Diagnostics returns the problems found with the pipelines in the
+// latest commit checked on each ref the poller is watching, ordered by
+// ref and then file. It's safe to call while the poller is running.
+func (gp *gitPoller) Diagnostics() []runlet.Diagnostic {
+	gp.diagnosticsMu.Lock()
+	defer gp.diagnosticsMu.Unlock()
+
+	refs := make([]string, 0, len(gp.diagnostics))
+	for ref := range gp.diagnostics {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	diags := []runlet.Diagnostic{}
+	for _, ref := range refs {
+		diags = append(diags, gp.diagnostics[ref]...)
+	}
+
+	return diags
+}
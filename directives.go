@@ -0,0 +1,91 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	trailerRunPipeline  = "run-pipeline"
+	trailerSkipPipeline = "skip-pipeline"
+)
+
+var skipCIPattern = regexp.MustCompile(`(?i)\[(skip ci|ci skip)\]`)
+
+var trailerPattern = regexp.MustCompile(`^([A-Za-z0-9-]+):\s*(.*)$`)
+
+// directives are instructions to the poller about which pipelines to
+// trigger, given in a commit message.
+type directives struct {
+	// skip is set by "[skip ci]" or "[ci skip]" anywhere in the message
+	// and means no pipelines are triggered at all.
+	skip bool
+	// run and skipPipelines are the pipelines listed in Run-Pipeline and
+	// Skip-Pipeline trailers. If any pipelines are listed to run, only
+	// those are triggered.
+	run           []string
+	skipPipelines []string
+}
+
+// parseDirectives reads the directives out of a commit message.
+// Trailers are read from the last paragraph of the message, the same
+// way git does, and can list several pipelines separated by commas.
+func parseDirectives(msg string) directives {
+	d := directives{
+		skip: skipCIPattern.MatchString(msg),
+	}
+
+	paragraphs := strings.Split(strings.TrimSpace(msg), "\n\n")
+	if len(paragraphs) < 2 {
+		// A message that's only a subject line has no trailers.
+		return d
+	}
+
+	last := paragraphs[len(paragraphs)-1]
+	for _, line := range strings.Split(last, "\n") {
+		match := trailerPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		var names []string
+		for _, name := range strings.Split(match[2], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+
+		switch strings.ToLower(match[1]) {
+		case trailerRunPipeline:
+			d.run = append(d.run, names...)
+		case trailerSkipPipeline:
+			d.skipPipelines = append(d.skipPipelines, names...)
+		}
+	}
+
+	return d
+}
+
+// allows reports whether the pipeline with the given name should be
+// triggered.
+func (d directives) allows(name string) bool {
+	if d.skip {
+		return false
+	}
+
+	if len(d.run) > 0 && !contains(d.run, name) {
+		return false
+	}
+
+	return !contains(d.skipPipelines, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
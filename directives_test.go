@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestDirectives(t *testing.T) {
+	tests := []struct {
+		msg     string
+		allowed []string
+		denied  []string
+	}{
+		{
+			msg:     "fix the thing",
+			allowed: []string{"test", "deploy"},
+		},
+		{
+			msg:    "update docs [skip ci]",
+			denied: []string{"test", "deploy"},
+		},
+		{
+			msg:    "WIP\n\n[CI SKIP] while I figure this out",
+			denied: []string{"test"},
+		},
+		{
+			msg:     "hotfix\n\nSome context.\n\nRun-Pipeline: deploy\nSigned-off-by: someone",
+			allowed: []string{"deploy"},
+			denied:  []string{"test", "lint"},
+		},
+		{
+			msg:     "refactor\n\nSkip-Pipeline: lint, docs",
+			allowed: []string{"test", "deploy"},
+			denied:  []string{"lint", "docs"},
+		},
+		{
+			msg:     "both\n\nrun-pipeline: test\nrun-pipeline: lint\nskip-pipeline: lint",
+			allowed: []string{"test"},
+			denied:  []string{"lint", "deploy"},
+		},
+		{
+			// Trailers only count in the last paragraph.
+			msg:     "subject\n\nRun-Pipeline: deploy\n\nThe end.",
+			allowed: []string{"test", "deploy"},
+		},
+		{
+			// A subject line on its own is never a trailer.
+			msg:     "Run-Pipeline: deploy",
+			allowed: []string{"test", "deploy"},
+		},
+	}
+
+	for _, test := range tests {
+		d := parseDirectives(test.msg)
+
+		for _, name := range test.allowed {
+			if !d.allows(name) {
+				t.Errorf("expected %q to allow %v", test.msg, name)
+			}
+		}
+
+		for _, name := range test.denied {
+			if d.allows(name) {
+				t.Errorf("expected %q not to allow %v", test.msg, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// errorKind is what went wrong with the git work of a poller.
+type errorKind string
+
+const (
+	// errAuth means the remote rejected the poller's credentials, or
+	// wanted some and didn't get any. SSH remotes whose host key isn't
+	// trusted are auth errors too.
+	errAuth errorKind = "auth"
+	// errRepoNotFound means the remote doesn't exist, or at least
+	// doesn't admit to it.
+	errRepoNotFound errorKind = "repo-not-found"
+	// errBranchNotFound means the remote doesn't have the branch the
+	// poller watches.
+	errBranchNotFound errorKind = "branch-not-found"
+	// errNetwork means the remote couldn't be reached, like when DNS
+	// fails, the connection is refused or it times out.
+	errNetwork errorKind = "network"
+	// errPipelinesMissing means a commit doesn't have the directory
+	// pipelines are read from.
+	errPipelinesMissing errorKind = "pipelines-missing"
+	// errUnknown is anything else.
+	errUnknown errorKind = "unknown"
+)
+
+// permanent returns true for errors that won't go away by trying again,
+// but need someone to fix the remote or the poller.
+func (k errorKind) permanent() bool {
+	switch k {
+	case errAuth, errRepoNotFound, errBranchNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// gitError is an error from the git work of a poller, along with what
+// kind of error it is.
+type gitError struct {
+	kind errorKind
+	err  error
+}
+
+func (e *gitError) Error() string {
+	return e.err.Error()
+}
+
+// classify works out what kind of error err is. Errors that are
+// already classified are returned as they are.
+func classify(err error) *gitError {
+	if gerr, ok := err.(*gitError); ok {
+		return gerr
+	}
+
+	return &gitError{kind: kindOf(err), err: err}
+}
+
+// kindOf looks through the errors go-git wraps others in for one it
+// recognizes.
+func kindOf(err error) errorKind {
+	for err != nil {
+		switch e := err.(type) {
+		case *gitError:
+			return e.kind
+		case *plumbing.PermanentError:
+			err = e.Err
+			continue
+		case *plumbing.UnexpectedError:
+			err = e.Err
+			continue
+		case *url.Error:
+			err = e.Err
+			continue
+		case *knownhosts.KeyError, *knownhosts.RevokedError:
+			return errAuth
+		case net.Error:
+			return errNetwork
+		}
+
+		switch err {
+		case transport.ErrAuthenticationRequired, transport.ErrAuthorizationFailed, transport.ErrInvalidAuthMethod:
+			return errAuth
+		case transport.ErrRepositoryNotFound:
+			return errRepoNotFound
+		}
+
+		// The SSH client flattens errors from the handshake into
+		// strings, so failed logins and host key checks can only be told
+		// apart by their messages.
+		for _, msg := range sshAuthMessages {
+			if strings.Contains(err.Error(), msg) {
+				return errAuth
+			}
+		}
+
+		return errUnknown
+	}
+
+	return errUnknown
+}
+
+// sshAuthMessages are what SSH errors say when the remote rejected the
+// poller's key, or the poller rejected the remote's host key.
+var sshAuthMessages = []string{
+	"ssh: unable to authenticate",
+	"knownhosts: key mismatch",
+	"knownhosts: key is unknown",
+	"knownhosts: key is revoked",
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+func TestClassify(t *testing.T) {
+	dnsErr := &url.Error{
+		Op:  "Get",
+		URL: "https://nonexistent.invalid/repo.git",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "nonexistent.invalid"}},
+	}
+
+	tests := []struct {
+		err       error
+		kind      errorKind
+		permanent bool
+	}{
+		{err: transport.ErrAuthenticationRequired, kind: errAuth, permanent: true},
+		{err: transport.ErrAuthorizationFailed, kind: errAuth, permanent: true},
+		{err: transport.ErrRepositoryNotFound, kind: errRepoNotFound, permanent: true},
+		{err: plumbing.NewPermanentError(transport.ErrRepositoryNotFound), kind: errRepoNotFound, permanent: true},
+		{err: &gitError{kind: errBranchNotFound, err: errors.New("no branch")}, kind: errBranchNotFound, permanent: true},
+		{err: errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"), kind: errAuth, permanent: true},
+		{err: errors.New("ssh: handshake failed: knownhosts: key mismatch"), kind: errAuth, permanent: true},
+		{err: errors.New("ssh: handshake failed: knownhosts: key is unknown"), kind: errAuth, permanent: true},
+		{err: &knownhosts.KeyError{}, kind: errAuth, permanent: true},
+		{err: &knownhosts.RevokedError{}, kind: errAuth, permanent: true},
+		{err: dnsErr, kind: errNetwork},
+		{err: plumbing.NewUnexpectedError(dnsErr), kind: errNetwork},
+		{err: &gitError{kind: errPipelinesMissing, err: errors.New("no pipelines")}, kind: errPipelinesMissing},
+		{err: errors.New("something else"), kind: errUnknown},
+	}
+
+	for _, test := range tests {
+		gerr := classify(test.err)
+		if gerr.kind != test.kind || gerr.kind.permanent() != test.permanent {
+			t.Fatalf("expected %v to be %v (permanent %v), got %v", test.err, test.kind, test.permanent, gerr.kind)
+		}
+
+		if gerr.Error() != test.err.Error() {
+			t.Fatalf("expected the message to be kept, got %q", gerr.Error())
+		}
+	}
+}
+
+func TestPollSuspends(t *testing.T) {
+	lifecycle := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote:   "/nonexistent/repo",
+		branch:   "master",
+		interval: time.Millisecond,
+
+		breaker: breaker{
+			threshold:  5,
+			maxBackoff: time.Hour,
+		},
+
+		lifecycle: lifecycle,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = gp.Poll(ctx)
+	}()
+
+	select {
+	case buf := <-lifecycle:
+		var ev lifecycleEvent
+		err := json.Unmarshal(buf, &ev)
+		if err != nil {
+			t.Fatalf("got error unmarshaling lifecycle event: %v", err)
+		}
+
+		if ev.Type != lifecycleSuspended || ev.Kind != string(errRepoNotFound) {
+			t.Fatalf("expected a suspended event for a missing repo, got %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poller to be suspended")
+	}
+
+	if state, failures := gp.Breaker(); state != breakerSuspended || failures != 1 {
+		t.Fatalf("expected the poller to be suspended after one failure, got %v with %v", state, failures)
+	}
+}
+
+func TestCheckRepoMissingPipelines(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"README.md": "hello",
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("expected a missing pipelines directory not to fail the check, got %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected no events, got %v", len(evs))
+	}
+
+	diags := gp.Diagnostics()
+	if len(diags) != 1 || diags[0].File != "pipelines" || diags[0].Message != "commit has no pipelines directory" {
+		t.Fatalf("expected a diagnostic about the missing directory, got %+v", diags)
+	}
+}
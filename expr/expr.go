@@ -0,0 +1,529 @@
+// Package expr parses and evaluates the conditions pipelines and steps
+// can put in `when`, like
+//
+//	branch == "main" && !(message =~ "wip*") && changed("deploy/**")
+//
+// Conditions compare the values of identifiers with string literals.
+// The identifiers are branch, tag, author, author_email and message,
+// which are empty when they don't apply, like tag for a branch push.
+//
+// The operators are == and != for exact comparisons, =~ and !~ for
+// matching a glob from the glob package, and &&, || and ! for combining
+// conditions, with parentheses for grouping. Globs match branch and tag
+// one slash-separated segment at a time, like glob.Match, and match
+// everything else as free text, where "*" matches slashes and newlines
+// too, like glob.MatchText. The functions are
+// changed(glob), which is true if a path matching the glob changed, and
+// contains(value, substring). true and false are conditions too.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/run-ci/git-poller/glob"
+)
+
+// Env is what conditions are evaluated against.
+type Env struct {
+	Branch      string
+	Tag         string
+	Author      string
+	AuthorEmail string
+	Message     string
+
+	// Changed are the paths that changed, or nil if they aren't known.
+	// When they aren't known, changed() is always true.
+	Changed []string
+}
+
+// Expr is a parsed condition.
+type Expr struct {
+	src  string
+	root boolNode
+}
+
+// Parse parses a condition, returning an error with the column of the
+// problem if it's malformed.
+func Parse(src string) (*Expr, error) {
+	p := &parser{src: src}
+
+	err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %v", tok)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+// Eval reports whether the condition holds in env.
+func (e *Expr) Eval(env Env) bool {
+	return e.root.eval(env)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// boolNode is a part of a condition that's true or false.
+type boolNode interface {
+	eval(env Env) bool
+}
+
+// stringNode is a part of a condition that's a string.
+type stringNode interface {
+	value(env Env) string
+}
+
+type literal string
+
+func (l literal) value(env Env) string {
+	return string(l)
+}
+
+type ident string
+
+var idents = map[string]func(Env) string{
+	"branch":       func(env Env) string { return env.Branch },
+	"tag":          func(env Env) string { return env.Tag },
+	"author":       func(env Env) string { return env.Author },
+	"author_email": func(env Env) string { return env.AuthorEmail },
+	"message":      func(env Env) string { return env.Message },
+}
+
+func (i ident) value(env Env) string {
+	return idents[string(i)](env)
+}
+
+type boolean bool
+
+func (b boolean) eval(env Env) bool {
+	return bool(b)
+}
+
+type not struct {
+	x boolNode
+}
+
+func (n not) eval(env Env) bool {
+	return !n.x.eval(env)
+}
+
+type and struct {
+	x, y boolNode
+}
+
+func (a and) eval(env Env) bool {
+	return a.x.eval(env) && a.y.eval(env)
+}
+
+type or struct {
+	x, y boolNode
+}
+
+func (o or) eval(env Env) bool {
+	return o.x.eval(env) || o.y.eval(env)
+}
+
+// refIdents are the identifiers that hold slash-separated names, which
+// are matched against globs one segment at a time. Everything else is
+// free text, where "*" matches slashes and newlines too.
+var refIdents = map[string]bool{
+	"branch": true,
+	"tag":    true,
+}
+
+type compare struct {
+	op   string
+	x, y stringNode
+	// ref is true if x is a slash-separated name.
+	ref bool
+}
+
+// match reports whether x matches the glob y.
+func (c compare) match(x, y string) bool {
+	if c.ref {
+		return glob.Match(y, x)
+	}
+
+	return glob.MatchText(y, x)
+}
+
+func (c compare) eval(env Env) bool {
+	x, y := c.x.value(env), c.y.value(env)
+
+	switch c.op {
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case "=~":
+		return c.match(x, y)
+	default:
+		return !c.match(x, y)
+	}
+}
+
+type changed struct {
+	pattern stringNode
+}
+
+func (c changed) eval(env Env) bool {
+	if env.Changed == nil {
+		return true
+	}
+
+	pattern := c.pattern.value(env)
+	for _, p := range env.Changed {
+		if glob.Match(pattern, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type contains struct {
+	x, y stringNode
+}
+
+func (c contains) eval(env Env) bool {
+	return strings.Contains(c.x.value(env), c.y.value(env))
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	col  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of condition"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("column %v: %v", tok.col, fmt.Sprintf(format, args...))
+}
+
+// scan splits the source into tokens.
+func (p *parser) scan() error {
+	src := p.src
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		col := i + 1
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			j := i
+			for j < len(src) && (src[j] == '_' || (src[j] >= 'a' && src[j] <= 'z') || (src[j] >= 'A' && src[j] <= 'Z') || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+
+			p.toks = append(p.toks, token{kind: tokIdent, text: src[i:j], col: col})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+
+			if j >= len(src) {
+				return fmt.Errorf("column %v: unterminated string", col)
+			}
+
+			text := src[i+1 : j]
+			if c == '"' {
+				var err error
+				text, err = strconv.Unquote(src[i : j+1])
+				if err != nil {
+					return fmt.Errorf("column %v: invalid string: %v", col, err)
+				}
+			}
+
+			p.toks = append(p.toks, token{kind: tokString, text: text, col: col})
+			i = j + 1
+		case c == '(' || c == ')' || c == ',':
+			p.toks = append(p.toks, token{kind: tokOp, text: string(c), col: col})
+			i++
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "=~", "!~", "!"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+
+			if op == "" {
+				return fmt.Errorf("column %v: unexpected character %q", col, c)
+			}
+
+			p.toks = append(p.toks, token{kind: tokOp, text: op, col: col})
+			i += len(op)
+		}
+	}
+
+	p.toks = append(p.toks, token{kind: tokEOF, col: len(src) + 1})
+
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) isOp(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == text
+}
+
+func (p *parser) expectOp(text string) error {
+	tok := p.next()
+	if tok.kind != tokOp || tok.text != text {
+		return p.errorf(tok, "expected %q, got %v", text, tok)
+	}
+
+	return nil
+}
+
+func (p *parser) parseOr() (boolNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("||") {
+		p.next()
+
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		x = or{x, y}
+	}
+
+	return x, nil
+}
+
+func (p *parser) parseAnd() (boolNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("&&") {
+		p.next()
+
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		x = and{x, y}
+	}
+
+	return x, nil
+}
+
+func (p *parser) parseUnary() (boolNode, error) {
+	if p.isOp("!") {
+		p.next()
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return not{x}, nil
+	}
+
+	if p.isOp("(") {
+		p.next()
+
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expectOp(")")
+		if err != nil {
+			return nil, err
+		}
+
+		return x, nil
+	}
+
+	tok := p.peek()
+	if tok.kind == tokIdent {
+		switch tok.text {
+		case "true", "false":
+			p.next()
+			return boolean(tok.text == "true"), nil
+		case "changed", "contains":
+			return p.parseCall()
+		}
+	}
+
+	return p.parseCompare()
+}
+
+func (p *parser) parseCall() (boolNode, error) {
+	name := p.next()
+
+	err := p.expectOp("(")
+	if err != nil {
+		return nil, err
+	}
+
+	args := []stringNode{}
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			err := p.expectOp(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+	p.next()
+
+	switch name.text {
+	case "changed":
+		if len(args) != 1 {
+			return nil, p.errorf(name, "changed takes one glob, got %v arguments", len(args))
+		}
+
+		err := validateGlob(args[0], glob.Validate)
+		if err != nil {
+			return nil, p.errorf(name, "%v", err)
+		}
+
+		return changed{args[0]}, nil
+	default:
+		if len(args) != 2 {
+			return nil, p.errorf(name, "contains takes a value and a substring, got %v arguments", len(args))
+		}
+
+		return contains{args[0], args[1]}, nil
+	}
+}
+
+func (p *parser) parseCompare() (boolNode, error) {
+	x, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.next()
+	if tok.kind != tokOp || (tok.text != "==" && tok.text != "!=" && tok.text != "=~" && tok.text != "!~") {
+		return nil, p.errorf(tok, "expected a comparison, got %v", tok)
+	}
+
+	y, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := x.(ident)
+	ref := ok && refIdents[string(id)]
+
+	if tok.text == "=~" || tok.text == "!~" {
+		validate := glob.ValidateText
+		if ref {
+			validate = glob.Validate
+		}
+
+		err := validateGlob(y, validate)
+		if err != nil {
+			return nil, p.errorf(tok, "%v", err)
+		}
+	}
+
+	return compare{op: tok.text, x: x, y: y, ref: ref}, nil
+}
+
+func (p *parser) parseValue() (stringNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokString:
+		return literal(tok.text), nil
+	case tokIdent:
+		if _, ok := idents[tok.text]; !ok {
+			return nil, p.errorf(tok, "unknown identifier %v", tok.text)
+		}
+
+		return ident(tok.text), nil
+	default:
+		return nil, p.errorf(tok, "expected a value, got %v", tok)
+	}
+}
+
+// validateGlob checks globs that are written out in the condition.
+// Globs that come from identifiers can only be checked when they match.
+func validateGlob(n stringNode, validate func(string) error) error {
+	l, ok := n.(literal)
+	if !ok {
+		return nil
+	}
+
+	err := validate(string(l))
+	if err != nil {
+		return errors.New("invalid glob " + strconv.Quote(string(l)))
+	}
+
+	return nil
+}
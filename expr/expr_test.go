@@ -0,0 +1,123 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Branch:      "main",
+		Author:      "Test Author",
+		AuthorEmail: "author@example.com",
+		Message:     "Deploy the thing\n\nfor real",
+		Changed:     []string{"deploy/prod.yaml", "README.md"},
+	}
+
+	tests := []struct {
+		src      string
+		expected bool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`branch == "main"`, true},
+		{`branch != "main"`, false},
+		{`"main" == branch`, true},
+		{`tag == ""`, true},
+		{`branch =~ "ma*"`, true},
+		{`branch !~ "release/*"`, true},
+		{`author_email =~ "*@example.com"`, true},
+		{`message =~ "Deploy*"`, true},
+		{`message =~ "*for real"`, true},
+		{`message =~ "*the thing?*"`, true},
+		{`branch =~ "m*n"`, true},
+		{`author == 'Test Author'`, true},
+		{`contains(message, "Deploy")`, true},
+		{`contains(message, "wip")`, false},
+		{`changed("deploy/**")`, true},
+		{`changed("src/**")`, false},
+		{`!changed("src/**")`, true},
+		{`branch == "main" && changed("**/*.md")`, true},
+		{`branch == "dev" || tag =~ "v*"`, false},
+		{`branch == "dev" || branch == "main" && false`, false},
+		{`(branch == "dev" || branch == "main") && !false`, true},
+		{`!!(branch == "main")`, true},
+		{`message == "Deploy the thing\n\nfor real"`, true},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("expected %v to parse, got %v", test.src, err)
+			continue
+		}
+
+		if actual := e.Eval(env); actual != test.expected {
+			t.Errorf("expected %v to be %v, got %v", test.src, test.expected, actual)
+		}
+	}
+
+	// Changes that aren't known count as matching.
+	e, _ := Parse(`changed("src/**")`)
+	if !e.Eval(Env{}) {
+		t.Error("expected changed() to be true when changes aren't known")
+	}
+}
+
+func TestEvalTextGlobs(t *testing.T) {
+	tests := []struct {
+		src      string
+		env      Env
+		expected bool
+	}{
+		{`message =~ "wip*"`, Env{Message: "wip: fix api/handler"}, true},
+		{`!(message =~ "wip*")`, Env{Message: "wip: fix api/handler"}, false},
+		{`message =~ "*deploy*"`, Env{Message: "deploy\n\nsee docs/x"}, true},
+		{`message !~ "*deploy*"`, Env{Message: "fix docs/x"}, true},
+		{`author =~ "*/bot"`, Env{Author: "ci/bot"}, true},
+		{`author_email =~ "*@example.com"`, Env{AuthorEmail: "a/b@example.com"}, true},
+		// Branches and tags are still matched a segment at a time.
+		{`branch =~ "release/*"`, Env{Branch: "release/1.0/hotfix"}, false},
+		{`branch =~ "release/**"`, Env{Branch: "release/1.0/hotfix"}, true},
+		{`tag =~ "v*"`, Env{Tag: "v1/rc"}, false},
+	}
+
+	for _, test := range tests {
+		e, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("expected %v to parse, got %v", test.src, err)
+			continue
+		}
+
+		if actual := e.Eval(test.env); actual != test.expected {
+			t.Errorf("expected %v to be %v against %+v, got %v", test.src, test.expected, test.env, actual)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{``, "column 1: expected a value, got end of condition"},
+		{`branch`, "column 7: expected a comparison"},
+		{`branch = "main"`, "column 8: unexpected character"},
+		{`commiter == "me"`, "column 1: unknown identifier commiter"},
+		{`branch == "main`, "column 11: unterminated string"},
+		{`(branch == "main"`, "column 18: expected \")\""},
+		{`branch == "main")`, "column 17: unexpected \")\""},
+		{`branch =~ "release/["`, "column 8: invalid glob"},
+		{`message =~ "[z-a]*"`, "column 9: invalid glob"},
+		{`changed("a", "b")`, "changed takes one glob"},
+		{`contains(message)`, "contains takes a value and a substring"},
+		{`branch == "main" &&`, "column 20: expected a value"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected %q to fail with %q, got %v", test.src, test.err, err)
+		}
+	}
+}
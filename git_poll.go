@@ -4,25 +4,86 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/run-ci/git-poller/expr"
+	"github.com/run-ci/git-poller/glob"
+	"github.com/run-ci/git-poller/mirror"
 	"github.com/run-ci/git-poller/runlet"
+	"github.com/run-ci/git-poller/throttle"
 	"github.com/sirupsen/logrus"
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
-	yaml "gopkg.in/yaml.v2"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
-type gitPoller struct {
-	remote   string
-	branch   string
-	lastHead string
+const (
+	// Only the latest commit triggers pipelines. This is the default.
+	modeLatest = "latest"
+	// Every new commit triggers pipelines, oldest first.
+	modeEvery = "every"
+	// The latest commit triggers pipelines, with the events listing all
+	// of the new commits.
+	modeBatched = "batched"
+)
 
-	queue chan<- []byte
+const branchRefPrefix = "refs/heads/"
+
+type gitPoller struct {
+	remote string
+	// branch is either a branch name or a pattern of branch names.
+	branch string
+	mode   string
+	// heads maps the branches being watched to the last head seen for
+	// them. It's nil until the remote's branches are first listed.
+	heads map[string]string
+
+	// Tag pollers watch the tags matching tags instead of a branch.
+	// seenTags is nil until the remote's tags are first listed.
+	tags     string
+	seenTags map[string]plumbing.Hash
+
+	// interval is how long the poller waits between checks. Adaptive
+	// pollers change it within their bounds as the repo gets busier or
+	// quieter, and the HTTP server reads it while the poller runs.
+	intervalMu sync.Mutex
+	interval   time.Duration
+	adaptive   bool
+	bounds     intervals
+
+	// breaker backs off checks of a remote that keeps failing.
+	breaker breaker
+
+	// changes counts the checks that found a head change, so Poll can
+	// tell whether the last one did.
+	changes int
+
+	// discovery is how pipelines are found in the repo.
+	discovery discovery
+
+	// auth is used to access the remote. It's nil for public remotes.
+	auth transport.AuthMethod
+
+	// diagnostics are the problems found with the pipelines in the
+	// latest commit on each ref, keyed by ref. They're read by the HTTP
+	// server while the poller runs.
+	diagnosticsMu sync.Mutex
+	diagnostics   map[string][]runlet.Diagnostic
+
+	// limiter limits the git operations of all pollers, and may be nil.
+	limiter *throttle.Limiter
+
+	mirrors        *mirror.Cache
+	queue          chan<- []byte
+	lifecycle      chan<- []byte
+	pipelineErrors chan<- []byte
 }
 
 func (gp *gitPoller) Poll(ctx context.Context) error {
@@ -32,149 +93,696 @@ func (gp *gitPoller) Poll(ctx context.Context) error {
 		"branch": gp.branch,
 	})
 
-	done := ctx.Done()
-	for {
-		select {
-		case <-done:
-			logger.Info("context done, shutting down poller")
+	wait := jitter(gp.Interval())
+	logger.Debugf("waiting %v before the first check", wait)
 
-			return nil
-		default:
-			logger.Info("running poller")
+	for sleep(ctx, wait) {
+		logger.Info("running poller")
 
-			err := gp.checkRepo()
-			if err != nil {
-				logger.WithError(err).Error("unable to clone git repo")
+		gp.breaker.attempt()
+
+		changes := gp.changes
+		err := gp.checkRepo()
+		if err != nil {
+			gerr := classify(err)
+			logger := logger.WithError(gerr).WithField("error_kind", gerr.kind)
+
+			var event string
+			wait, event = gp.breaker.failure(gp.Interval(), gerr.kind.permanent())
+			logger.Errorf("unable to check git repo, retrying in %v", wait)
+
+			if event != "" {
+				logger.Warnf("poller %v", event)
+
+				gp.publishLifecycle(lifecycleEvent{
+					Type:  event,
+					Kind:  string(gerr.kind),
+					Error: gerr.Error(),
+				})
 			}
 
-			logger.Debug("sleeping")
-			time.Sleep(1 * time.Minute)
+			continue
 		}
+
+		if event := gp.breaker.success(); event != "" {
+			logger.Infof("poller %v", event)
+
+			gp.publishLifecycle(lifecycleEvent{
+				Type: event,
+			})
+		}
+
+		wait = gp.adapt(gp.changes != changes)
+		logger.Debugf("sleeping for %v", wait)
 	}
+
+	logger.Info("context done, shutting down poller")
+
+	return nil
 }
 
+// checkRepo checks the remote for changes to the refs the poller is
+// watching, and triggers pipelines for any that changed.
 func (gp *gitPoller) checkRepo() error {
+	if gp.tags != "" {
+		return gp.checkTags()
+	}
+
+	return gp.checkBranches()
+}
+
+// checkBranches triggers pipelines for the branches the poller watches
+// whose heads changed. Pollers either watch one branch, or every branch
+// matching a pattern like "release/*". Pattern pollers also publish
+// lifecycle events when matching branches appear or disappear.
+func (gp *gitPoller) checkBranches() error {
 	logger := logger.WithFields(logrus.Fields{
 		"poll":   "git",
 		"remote": gp.remote,
 		"branch": gp.branch,
 	})
 
-	clonedir := fmt.Sprintf("/tmp/git-poller.%v", uuid.New())
+	// Asking the remote for its refs is a lot cheaper than cloning it, so
+	// that's done first to find out if there's anything to do at all.
+	refs, err := gp.lsRemote()
+	if err != nil {
+		logger.WithError(err).Debug("unable to list remote refs")
+		return err
+	}
+
+	current := map[string]plumbing.Hash{}
+	for _, ref := range refs {
+		name := ref.Name().String()
+		if !strings.HasPrefix(name, branchRefPrefix) {
+			continue
+		}
+
+		branch := strings.TrimPrefix(name, branchRefPrefix)
+		if glob.Match(gp.branch, branch) {
+			current[branch] = ref.Hash()
+		}
+	}
+
+	if !glob.IsPattern(gp.branch) && len(current) == 0 {
+		err := &gitError{
+			kind: errBranchNotFound,
+			err:  fmt.Errorf("remote has no ref %v%v", branchRefPrefix, gp.branch),
+		}
+		logger.WithError(err).Debug("branch not found on remote")
+		return err
+	}
+
+	// Pattern pollers take the branches that exist when they first check
+	// the remote as seen, like tag pollers do with tags, so that starting
+	// one doesn't trigger pipelines for every matching branch at once.
+	// Pollers of a single branch trigger for its head straight away.
+	if gp.heads == nil && glob.IsPattern(gp.branch) {
+		logger.Infof("found %v existing branches, waiting for changes", len(current))
+
+		gp.heads = map[string]string{}
+		for branch, head := range current {
+			gp.heads[branch] = head.String()
+		}
+
+		return nil
+	}
+
+	// The first listing is what the poller starts out watching. Only
+	// branches that come and go after that are lifecycle events.
+	firstCheck := gp.heads == nil
+	if firstCheck {
+		gp.heads = map[string]string{}
+	}
+
+	for branch, head := range gp.heads {
+		if _, ok := current[branch]; ok {
+			continue
+		}
+
+		logger.WithField("branch", branch).Info("branch deleted")
+
+		delete(gp.heads, branch)
+		gp.forgetDiagnostics(branchRefPrefix + branch)
+		gp.publishLifecycle(lifecycleEvent{
+			Type:   lifecycleBranchDeleted,
+			Branch: branch,
+			Head:   head,
+		})
+	}
+
+	changed := []string{}
+	for branch, head := range current {
+		logger.WithField("branch", branch).Debugf("remote advertised head %v", head)
+
+		if head.String() != gp.heads[branch] {
+			changed = append(changed, branch)
+		}
+	}
+
+	if len(changed) == 0 {
+		logger.Debug("heads unchanged, nothing to do")
+		return nil
+	}
 
-	opts := &git.CloneOptions{
-		URL:           gp.remote,
-		ReferenceName: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%v", gp.branch)),
-		SingleBranch:  true,
-		Depth:         1,
+	if !firstCheck {
+		gp.changes++
 	}
 
-	logger.Infof("cloning into %v", clonedir)
+	sort.Strings(changed)
+
+	// Everything past this point reads from the mirror, which needs to be
+	// brought up to date first. That only downloads the objects that are
+	// new since the last fetch.
+	logger.Info("fetching into mirror")
 
-	repo, err := git.PlainClone(clonedir, false, opts)
+	m, err := gp.fetch()
 	if err != nil {
-		logger.WithError(err).Debug("unable to clone repo")
+		logger.WithError(err).Debug("unable to fetch repo")
 		return err
 	}
+	defer m.Release()
 
-	head, err := repo.Head()
-	if err != nil {
-		logger.WithError(err).Debug("unable to get repo HEAD")
+	for _, branch := range changed {
+		_, known := gp.heads[branch]
+
+		err := gp.checkHead(m, branch)
+
+		// New branches are only announced once they're in gp.heads, so
+		// that a failed check doesn't announce them again next time. That
+		// can happen partway through a failed check in the every mode.
+		if _, ok := gp.heads[branch]; ok && !known && !firstCheck {
+			logger.WithField("branch", branch).Info("branch created")
+
+			gp.publishLifecycle(lifecycleEvent{
+				Type:   lifecycleBranchCreated,
+				Branch: branch,
+				Head:   current[branch].String(),
+			})
+		}
 
-		cleanerr := os.RemoveAll(clonedir)
 		if err != nil {
-			logger.WithError(cleanerr).Debugf("unable to clean up clonedir %v", clonedir)
+			return err
 		}
+	}
 
+	return nil
+}
+
+// checkHead triggers pipelines for the commits on the branch that are
+// new since its last seen head.
+func (gp *gitPoller) checkHead(m *mirror.Mirror, branch string) error {
+	logger := logger.WithFields(logrus.Fields{
+		"poll":   "git",
+		"remote": gp.remote,
+		"branch": branch,
+	})
+
+	head, err := m.Reference(plumbing.ReferenceName(branchRefPrefix+branch), true)
+	if err != nil {
+		logger.WithError(err).Debug("unable to get branch head from mirror")
 		return err
 	}
 
 	logger.Infof("got repo head %v", head)
-	if head.String() != gp.lastHead {
-		logger.Info("head changed, parsing pipelines")
+	logger.Info("head changed, parsing pipelines")
 
-		files, err := ioutil.ReadDir(fmt.Sprintf("%v/pipelines", clonedir))
-		if err != nil {
-			logger.WithError(err).Debug("unable to list pipeline files")
-			cleanerr := os.RemoveAll(clonedir)
+	lastHead := gp.heads[branch]
+
+	commits, err := commitRange(m.Repository, plumbing.NewHash(lastHead), head.Hash())
+	if err != nil {
+		logger.WithError(err).Debug("unable to list new commits")
+		return err
+	}
+
+	logger.Debugf("found %v new commits", len(commits))
+
+	// Changes are only known relative to a head that was seen before. On
+	// the first check, pipelines trigger regardless of their paths.
+	seenBefore := lastHead != ""
+
+	switch gp.mode {
+	case modeEvery:
+		for _, commit := range commits {
+			var changed []string
+			if seenBefore {
+				changed, err = changedPaths(m.Repository, firstParent(commit), commit)
+				if err != nil {
+					logger.WithError(err).Debug("unable to diff commit against its parent")
+					return err
+				}
+			}
+
+			err := gp.publishPipelines(trigger{
+				branch:       branch,
+				commit:       commit,
+				commits:      []*object.Commit{commit},
+				previousHead: gp.heads[branch],
+				changed:      changed,
+			})
 			if err != nil {
-				logger.WithError(cleanerr).Debugf("unable to clean up clonedir %v", clonedir)
+				return err
 			}
-			return err
+
+			// Each commit is done as soon as its pipelines are out, so that
+			// a failure partway through doesn't trigger them all again.
+			gp.heads[branch] = commit.Hash.String()
 		}
-		if len(files) == 0 {
-			cleanerr := os.RemoveAll(clonedir)
+	default:
+		last := commits[len(commits)-1]
+
+		var changed []string
+		if seenBefore {
+			changed, err = changedPaths(m.Repository, plumbing.NewHash(lastHead), last)
 			if err != nil {
-				logger.WithError(cleanerr).Debugf("unable to clean up clonedir %v", clonedir)
+				logger.WithError(err).Debug("unable to diff new head against old head")
+				return err
 			}
+		}
 
-			return nil
+		covered := []*object.Commit{last}
+		if gp.mode == modeBatched {
+			covered = commits
 		}
 
-		for _, finfo := range files {
-			name := strings.Split(finfo.Name(), ".")[0]
-			logger := logger.WithField("pipeline_name", name)
+		err := gp.publishPipelines(trigger{
+			branch:       branch,
+			commit:       last,
+			commits:      covered,
+			previousHead: lastHead,
+			changed:      changed,
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-			path := fmt.Sprintf("%v/pipelines/%v", clonedir, finfo.Name())
-			f, err := os.Open(path)
-			if err != nil {
-				logger.WithError(err).
-					Debugf("unable to open pipeline file at %v, skipping", path)
+	gp.heads[branch] = head.Hash().String()
+	return nil
+}
 
-				continue
-			}
+// trigger is a change to a ref that pipelines are published for.
+type trigger struct {
+	// Exactly one of branch and tag is set, depending on the kind of
+	// ref that changed.
+	branch     string
+	tag        string
+	tagMessage string
 
-			buf, err := ioutil.ReadAll(f)
-			if err != nil {
-				logger.WithError(err).
-					Debugf("unable to read pipeline file at %v, skipping", path)
+	// commit is the commit the pipelines are read from, and commits are
+	// all the commits the trigger covers, oldest first.
+	commit  *object.Commit
+	commits []*object.Commit
+
+	// previousHead is the head of the branch before the trigger, if any.
+	previousHead string
+
+	// changed are the paths that changed, or nil if they aren't known.
+	changed []string
+}
+
+// matches reports whether the pipeline should run for the trigger.
+func (t trigger) matches(ev runlet.Event) bool {
+	if t.tag != "" {
+		return ev.MatchesTag(t.tag)
+	}
+
+	// Only trigger this specific pipeline if the pipeline's branches
+	// match the branch that is currently being listened to. If they only
+	// match branches that are handled by another poller, it should be
+	// ignored.
+	return ev.MatchesBranch(t.branch)
+}
+
+// publishPipelines parses the pipelines in the trigger's commit and
+// queues up the ones that should run for it.
+func (gp *gitPoller) publishPipelines(t trigger) error {
+	commit := t.commit
+
+	logger := logger.WithFields(logrus.Fields{
+		"poll":   "git",
+		"remote": gp.remote,
+		"branch": t.branch,
+		"tag":    t.tag,
+		"commit": commit.Hash.String(),
+	})
+
+	// The commit message can ask for pipelines to be skipped, and there's
+	// no use reading the pipelines if it asks to skip all of them.
+	directives := parseDirectives(commit.Message)
+	if directives.skip {
+		logger.Info("commit message asks to skip ci, nothing to trigger")
+		return nil
+	}
+
+	pipelines, errs, err := loadPipelinesAt(commit, gp.discovery)
+	if os.IsNotExist(err) {
+		// This isn't a failure of the check, since the commit is fine as
+		// far as git is concerned, but it's kept as a diagnostic so that
+		// a misconfigured poller doesn't look like a quiet one.
+		dir := gp.discovery.withDefaults().Dir
+		gerr := &gitError{kind: errPipelinesMissing, err: fmt.Errorf("commit has no %v directory", dir)}
+		logger.WithError(gerr).WithField("error_kind", gerr.kind).Warn("nothing to trigger")
+
+		gp.publishDiagnostics(t, []runlet.Diagnostic{runlet.NewDiagnostic(dir, gerr)})
+		return nil
+	}
+	if err != nil {
+		logger.WithError(err).Debug("unable to list pipeline files")
+		return err
+	}
+
+	diags := []runlet.Diagnostic{}
+	for _, perr := range errs {
+		logger.WithError(perr.err).
+			Infof("unable to load pipeline file at %v, skipping", perr.path)
+
+		diags = append(diags, runlet.NewDiagnostic(perr.path, perr.err))
+	}
 
+	commits := make([]runlet.Commit, len(t.commits))
+	for i, c := range t.commits {
+		commits[i] = commitMetadata(c)
+	}
+
+	env := expr.Env{
+		Branch:      t.branch,
+		Tag:         t.tag,
+		Author:      commit.Author.Name,
+		AuthorEmail: commit.Author.Email,
+		Message:     commit.Message,
+		Changed:     t.changed,
+	}
+
+	for _, p := range pipelines {
+		ev := p.Event
+		name := ev.Name
+		logger := logger.WithField("pipeline_name", name)
+
+		ev.Remote.URL = gp.remote
+		ev.Remote.Branch = t.branch
+		ev.Remote.Tag = t.tag
+		ev.Remote.TagMessage = t.tagMessage
+		ev.Remote.Commit = commitMetadata(commit)
+		ev.Remote.PreviousHead = t.previousHead
+		ev.Remote.Commits = commits
+
+		logger = logger.WithField("event", ev)
+
+		if !t.matches(ev) {
+			continue
+		}
+
+		if !directives.allows(name) {
+			logger.Debug("commit message directives exclude the pipeline, skipping")
+			continue
+		}
+
+		if !ev.MatchesPaths(t.changed) {
+			logger.Debug("no changed paths match the pipeline, skipping")
+			continue
+		}
+
+		ev, ok, err := ev.ApplyWhen(env)
+		if err != nil {
+			logger.WithError(err).
+				Infof("unable to evaluate conditions for %v, skipping", p.path)
+
+			continue
+		}
+
+		if !ok {
+			logger.Debug("pipeline conditions don't hold, skipping")
+			continue
+		}
+
+		ev, err = ev.ExpandArguments()
+		if err != nil {
+			logger.WithError(err).
+				Infof("unable to expand arguments for %v, skipping", p.path)
+
+			d := runlet.NewDiagnostic(p.path, err)
+			d.Pipeline = name
+			diags = append(diags, d)
+
+			continue
+		}
+
+		logger.Debug("pipeline matches ref and changes, triggering pipeline run")
+		jsonbuf, err := json.Marshal(ev)
+		if err != nil {
+			logger.WithError(err).
+				Debugf("unable to marshal event for %v, skipping", p.path)
+
+			continue
+		}
+
+		gp.queue <- jsonbuf
+	}
+
+	gp.publishDiagnostics(t, diags)
+
+	return nil
+}
+
+// commitMetadata converts a commit into the format it's sent to the
+// runlet in.
+func commitMetadata(c *object.Commit) runlet.Commit {
+	parents := make([]string, len(c.ParentHashes))
+	for i, parent := range c.ParentHashes {
+		parents[i] = parent.String()
+	}
+
+	return runlet.Commit{
+		SHA:     c.Hash.String(),
+		Parents: parents,
+		Author: runlet.Signature{
+			Name:  c.Author.Name,
+			Email: c.Author.Email,
+			Time:  c.Author.When,
+		},
+		Committer: runlet.Signature{
+			Name:  c.Committer.Name,
+			Email: c.Committer.Email,
+			Time:  c.Committer.When,
+		},
+		Message: c.Message,
+	}
+}
+
+// changedPaths lists the paths of the files that differ between the
+// trees of the commit at from and the given commit. If from is the zero
+// hash, every file in the commit counts as changed. If from isn't in
+// the repo, the changes can't be known and nil is returned.
+func changedPaths(repo *git.Repository, from plumbing.Hash, to *object.Commit) ([]string, error) {
+	var fromTree *object.Tree
+	if !from.IsZero() {
+		fromCommit, err := repo.CommitObject(from)
+		if err == plumbing.ErrObjectNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fromTree, err = fromCommit.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, change := range changes {
+		// Renames show up on both sides, and both the old and the new
+		// location count as changed.
+		if change.From.Name != "" {
+			paths = append(paths, change.From.Name)
+		}
+
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			paths = append(paths, change.To.Name)
+		}
+	}
+
+	return paths, nil
+}
+
+func firstParent(c *object.Commit) plumbing.Hash {
+	if len(c.ParentHashes) == 0 {
+		return plumbing.ZeroHash
+	}
+
+	return c.ParentHashes[0]
+}
+
+// maxRangeCommits caps how many commits are walked when working out
+// which commits are new. Anything beyond that is dropped, oldest first.
+const maxRangeCommits = 1000
+
+// commitRange returns the commits reachable from to that aren't
+// reachable from from, oldest first. It's the equivalent of
+// `git rev-list --topo-order --reverse from..to`. If from is the zero
+// hash or isn't in the repo, only the commit at to is returned.
+func commitRange(repo *git.Repository, from, to plumbing.Hash) ([]*object.Commit, error) {
+	head, err := repo.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if from.IsZero() {
+		return []*object.Commit{head}, nil
+	}
+
+	base, err := repo.CommitObject(from)
+	if err == plumbing.ErrObjectNotFound {
+		return []*object.Commit{head}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything that was already reachable from the old head was already
+	// seen. Walking it is bounded, so a merge of very old history could
+	// bring some of it back in, but that's an acceptable tradeoff against
+	// walking the whole history on every change.
+	seen := map[plumbing.Hash]bool{}
+	err = walk(base, maxRangeCommits, func(c *object.Commit) bool {
+		seen[c.Hash] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inrange := map[plumbing.Hash]*object.Commit{}
+	err = walk(head, maxRangeCommits, func(c *object.Commit) bool {
+		if seen[c.Hash] {
+			return false
+		}
+
+		inrange[c.Hash] = c
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := inrange[head.Hash]; !ok {
+		// The new head was already seen, for example after a branch was
+		// reset to an older commit. It still counts as a change.
+		return []*object.Commit{head}, nil
+	}
+
+	// Parents need to come before their children, so the range is put in
+	// order with a depth-first walk that emits commits on the way out.
+	commits := make([]*object.Commit, 0, len(inrange))
+	visited := map[plumbing.Hash]bool{}
+
+	var visit func(c *object.Commit) error
+	visit = func(c *object.Commit) error {
+		visited[c.Hash] = true
+
+		for _, parent := range c.ParentHashes {
+			pc, ok := inrange[parent]
+			if !ok || visited[parent] {
 				continue
 			}
 
-			var ev runlet.Event
-			err = yaml.UnmarshalStrict(buf, &ev)
+			err := visit(pc)
 			if err != nil {
-				logger.WithError(err).
-					Debugf("unable to unmarshal pipeline for %v, skipping", path)
-
-				continue
+				return err
 			}
+		}
 
-			ev.Remote.URL = gp.remote
-			ev.Remote.Branch = gp.branch
-			ev.Name = name
+		commits = append(commits, c)
+		return nil
+	}
 
-			logger = logger.WithField("event", ev)
+	err = visit(head)
+	if err != nil {
+		return nil, err
+	}
 
-			// Only trigger this specific pipeline if the pipeline specifies
-			// the branch that is currently being listened to. If the pipeline
-			// specifies a branch that's being handled by another poller, it
-			// should be ignored.
-			if gp.branch == ev.Branch {
-				logger.Debug("pipeline branch matches poller branch, triggering pipeline run")
-				jsonbuf, err := json.Marshal(ev)
-				if err != nil {
-					logger.WithError(err).
-						Debugf("unable to marshal event for %v, skipping", path)
+	return commits, nil
+}
 
-					continue
-				}
+// walk visits commits breadth-first starting at c, until limit commits
+// have been visited. Parents of a commit are only visited if fn returns
+// true for it.
+func walk(c *object.Commit, limit int, fn func(*object.Commit) bool) error {
+	queue := []*object.Commit{c}
+	queued := map[plumbing.Hash]bool{c.Hash: true}
 
-				gp.queue <- jsonbuf
+	for visited := 0; len(queue) > 0 && visited < limit; visited++ {
+		c := queue[0]
+		queue = queue[1:]
+
+		if !fn(c) {
+			continue
+		}
+
+		err := c.Parents().ForEach(func(parent *object.Commit) error {
+			if queued[parent.Hash] {
+				return nil
 			}
 
+			queued[parent.Hash] = true
+			queue = append(queue, parent)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+	}
 
-		gp.lastHead = head.String()
+	return nil
+}
+
+// lsRemote lists the refs the remote advertises along with the hashes
+// they point to, without downloading any objects. This is the
+// equivalent of `git ls-remote`.
+func (gp *gitPoller) lsRemote() ([]*plumbing.Reference, error) {
+	// The remote needs a repository to hang off of, but since nothing
+	// is fetched an empty in-memory one is enough.
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
 	}
 
-	err = os.RemoveAll(clonedir)
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{gp.remote},
+	})
 	if err != nil {
-		logger.WithError(err).Debugf("unable to clean up clonedir %v", clonedir)
-		return err
+		return nil, err
 	}
 
-	logger.Debug("clonedir successfully deleted")
-	return nil
+	var refs []*plumbing.Reference
+	err = gp.limiter.Do(gp.remote, func() error {
+		refs, err = remote.List(&git.ListOptions{
+			Auth: gp.auth,
+		})
+
+		return err
+	})
+
+	return refs, err
+}
+
+// fetch brings the remote's mirror up to date. Only the fetch from the
+// remote waits for the limits on git operations, so pollers waiting for
+// another one to release the mirror don't use up anyone's slots.
+func (gp *gitPoller) fetch() (*mirror.Mirror, error) {
+	return gp.mirrors.Fetch(gp.remote, gp.auth, func(fn func() error) error {
+		return gp.limiter.Do(gp.remote, fn)
+	})
 }
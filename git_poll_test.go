@@ -0,0 +1,792 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/run-ci/git-poller/mirror"
+	"github.com/run-ci/git-poller/runlet"
+	"github.com/run-ci/git-poller/throttle"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// testrepo is a git repository on local disk that pollers can
+// use as their remote.
+type testrepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newTestRepo(t *testing.T) *testrepo {
+	dir, err := ioutil.TempDir("", "git-poller-test")
+	if err != nil {
+		t.Fatalf("got error creating repo dir: %v", err)
+	}
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("got error initializing repo: %v", err)
+	}
+
+	return &testrepo{
+		t:    t,
+		dir:  dir,
+		repo: repo,
+	}
+}
+
+func (tr *testrepo) cleanup() {
+	os.RemoveAll(tr.dir)
+}
+
+func newTestCache(t *testing.T) (*mirror.Cache, func()) {
+	dir, err := ioutil.TempDir("", "git-poller-mirrors")
+	if err != nil {
+		t.Fatalf("got error creating mirror dir: %v", err)
+	}
+
+	cache, err := mirror.NewCache(dir, 0)
+	if err != nil {
+		t.Fatalf("got error creating mirror cache: %v", err)
+	}
+
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+// commit writes the given files to the worktree and commits them,
+// returning the hash of the new commit.
+func (tr *testrepo) commit(msg string, files map[string]string) plumbing.Hash {
+	wt, err := tr.repo.Worktree()
+	if err != nil {
+		tr.t.Fatalf("got error getting worktree: %v", err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(tr.dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			tr.t.Fatalf("got error creating directory for %v: %v", name, err)
+		}
+
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			tr.t.Fatalf("got error writing %v: %v", name, err)
+		}
+
+		_, err = wt.Add(name)
+		if err != nil {
+			tr.t.Fatalf("got error adding %v: %v", name, err)
+		}
+	}
+
+	hash, err := wt.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "Test Author",
+			Email: "author@example.com",
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		tr.t.Fatalf("got error committing: %v", err)
+	}
+
+	return hash
+}
+
+const testPipeline = `
+branch: master
+steps:
+- name: test
+  tasks:
+  - name: test
+`
+
+// drain collects all the events that were queued up.
+func drain(t *testing.T, ch <-chan []byte) []runlet.Event {
+	evs := []runlet.Event{}
+
+	for {
+		select {
+		case buf := <-ch:
+			var ev runlet.Event
+			err := json.Unmarshal(buf, &ev)
+			if err != nil {
+				t.Fatalf("got error unmarshalling event: %v", err)
+			}
+
+			evs = append(evs, ev)
+		default:
+			return evs
+		}
+	}
+}
+
+func TestCheckRepoOnlyTriggersOnChange(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml": testPipeline,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		limiter: throttle.New(1, nil),
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 1 {
+		t.Fatalf("expected 1 event on first check, got %v", len(evs))
+	}
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected no events when head hasn't changed, got %v", len(evs))
+	}
+
+	second := tr.commit("second commit", map[string]string{
+		"README.md": "hello",
+	})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event after head changed, got %v", len(evs))
+	}
+
+	if evs[0].Name != "test" {
+		t.Fatalf("expected event for pipeline test, got %v", evs[0].Name)
+	}
+
+	remote := evs[0].Remote
+	if remote.Commit.SHA != second.String() {
+		t.Fatalf("expected event for commit %v, got %v", second, remote.Commit.SHA)
+	}
+
+	if remote.PreviousHead != first.String() {
+		t.Fatalf("expected previous head %v, got %v", first, remote.PreviousHead)
+	}
+
+	if len(remote.Commit.Parents) != 1 || remote.Commit.Parents[0] != first.String() {
+		t.Fatalf("expected parents [%v], got %v", first, remote.Commit.Parents)
+	}
+
+	if remote.Commit.Author.Email != "author@example.com" || remote.Commit.Message != "second commit" {
+		t.Fatalf("expected commit metadata to be filled in, got %+v", remote.Commit)
+	}
+}
+
+func TestCheckRepoMissingBranch(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml": testPipeline,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "nope",
+
+		mirrors: mirrors,
+		queue:   make(chan []byte, 16),
+	}
+
+	err := gp.checkRepo()
+	if err == nil {
+		t.Fatal("expected error checking a branch that doesn't exist")
+	}
+
+	if kind := classify(err).kind; kind != errBranchNotFound {
+		t.Fatalf("expected a missing branch to be %v, got %v", errBranchNotFound, kind)
+	}
+}
+
+func TestCheckRepoModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		events  int
+		commits []int
+	}{
+		{mode: modeLatest, events: 1, commits: []int{1}},
+		{mode: modeEvery, events: 3, commits: []int{1, 1, 1}},
+		{mode: modeBatched, events: 1, commits: []int{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			tr := newTestRepo(t)
+			defer tr.cleanup()
+
+			tr.commit("initial commit", map[string]string{
+				"pipelines/test.yaml": testPipeline,
+			})
+
+			mirrors, cleanup := newTestCache(t)
+			defer cleanup()
+
+			queue := make(chan []byte, 16)
+			gp := &gitPoller{
+				remote: tr.dir,
+				branch: "master",
+				mode:   test.mode,
+
+				mirrors: mirrors,
+				queue:   queue,
+			}
+
+			err := gp.checkRepo()
+			if err != nil {
+				t.Fatalf("got error checking repo: %v", err)
+			}
+			drain(t, queue)
+
+			hashes := []plumbing.Hash{
+				tr.commit("one", map[string]string{"one": "1"}),
+				tr.commit("two", map[string]string{"two": "2"}),
+				tr.commit("three", map[string]string{"three": "3"}),
+			}
+
+			err = gp.checkRepo()
+			if err != nil {
+				t.Fatalf("got error checking repo: %v", err)
+			}
+
+			evs := drain(t, queue)
+			if len(evs) != test.events {
+				t.Fatalf("expected %v events, got %v", test.events, len(evs))
+			}
+
+			for i, ev := range evs {
+				if len(ev.Remote.Commits) != test.commits[i] {
+					t.Fatalf("expected event %v to have %v commits, got %v", i, test.commits[i], len(ev.Remote.Commits))
+				}
+			}
+
+			// Whatever the mode, the last commit listed in the last event
+			// should be the new head and everything should be oldest first.
+			last := evs[len(evs)-1].Remote.Commits
+			if last[len(last)-1].SHA != hashes[2].String() {
+				t.Fatalf("expected last commit to be %v, got %v", hashes[2], last[len(last)-1].SHA)
+			}
+
+			if test.mode == modeEvery {
+				for i, ev := range evs {
+					if ev.Remote.Commits[0].SHA != hashes[i].String() {
+						t.Fatalf("expected event %v to be for %v, got %v", i, hashes[i], ev.Remote.Commits[0].SHA)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRepoPathFilters(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml": testPipeline,
+		"pipelines/code.yaml": testPipeline + "ignore_paths:\n- docs/**\n",
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 2 {
+		t.Fatalf("expected both pipelines on first check, got %v", len(evs))
+	}
+
+	tr.commit("docs only", map[string]string{
+		"docs/index.md": "docs",
+	})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event for docs-only change, got %v", len(evs))
+	}
+
+	if evs[0].Name != "test" {
+		t.Fatalf("expected unfiltered pipeline to trigger, got %v", evs[0].Name)
+	}
+}
+
+func TestCheckRepoBranchPattern(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/one.yaml": "branch: release/1.0\nsteps: []\n",
+		"pipelines/two.yaml": "branch: release/2.0\nsteps: []\n",
+	})
+
+	setBranch := func(name string, hash plumbing.Hash) {
+		ref := plumbing.NewHashReference(plumbing.ReferenceName("refs/heads/"+name), hash)
+		if err := tr.repo.Storer.SetReference(ref); err != nil {
+			t.Fatalf("got error creating branch %v: %v", name, err)
+		}
+	}
+
+	setBranch("release/1.0", first)
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	lifecycle := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "release/*",
+
+		mirrors:   mirrors,
+		queue:     queue,
+		lifecycle: lifecycle,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected existing branches not to trigger, got %+v", evs)
+	}
+
+	if len(lifecycle) != 0 {
+		t.Fatalf("expected no lifecycle events for initial branches, got %v", len(lifecycle))
+	}
+
+	setBranch("release/2.0", first)
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Remote.Branch != "release/2.0" {
+		t.Fatalf("expected 1 event for release/2.0, got %+v", evs)
+	}
+
+	var ev lifecycleEvent
+	if err := json.Unmarshal(<-lifecycle, &ev); err != nil {
+		t.Fatalf("got error unmarshalling lifecycle event: %v", err)
+	}
+
+	if ev.Type != lifecycleBranchCreated || ev.Branch != "release/2.0" {
+		t.Fatalf("expected release/2.0 to be created, got %+v", ev)
+	}
+
+	err = tr.repo.Storer.RemoveReference("refs/heads/release/1.0")
+	if err != nil {
+		t.Fatalf("got error deleting branch: %v", err)
+	}
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected no events after deleting a branch, got %v", len(evs))
+	}
+
+	if err := json.Unmarshal(<-lifecycle, &ev); err != nil {
+		t.Fatalf("got error unmarshalling lifecycle event: %v", err)
+	}
+
+	if ev.Type != lifecycleBranchDeleted || ev.Branch != "release/1.0" {
+		t.Fatalf("expected release/1.0 to be deleted, got %+v", ev)
+	}
+}
+
+func TestCheckRepoBranchPatternFirstCheck(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml": "branches: [feature/*]\nsteps: []\n",
+	})
+
+	setBranch := func(name string, hash plumbing.Hash) {
+		ref := plumbing.NewHashReference(plumbing.ReferenceName("refs/heads/"+name), hash)
+		if err := tr.repo.Storer.SetReference(ref); err != nil {
+			t.Fatalf("got error creating branch %v: %v", name, err)
+		}
+	}
+
+	for _, branch := range []string{"feature/a", "feature/b", "feature/c"} {
+		setBranch(branch, first)
+	}
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "feature/*",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	// Starting a pattern poller, like after a restart, takes the branches
+	// that are already there as seen.
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected existing branches not to trigger, got %v events", len(evs))
+	}
+
+	second := tr.commit("second commit", map[string]string{"a": "a"})
+	setBranch("feature/b", second)
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Remote.Branch != "feature/b" {
+		t.Fatalf("expected 1 event for feature/b, got %+v", evs)
+	}
+
+	if evs[0].Remote.Commit.SHA != second.String() {
+		t.Fatalf("expected event for %v, got %v", second, evs[0].Remote.Commit.SHA)
+	}
+}
+
+func TestCheckRepoDirectives(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml":   testPipeline,
+		"pipelines/deploy.yaml": testPipeline,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+	drain(t, queue)
+
+	tr.commit("docs [skip ci]", map[string]string{"a": "a"})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected [skip ci] to skip all pipelines, got %v events", len(evs))
+	}
+
+	tr.commit("hotfix\n\nSkip-Pipeline: test", map[string]string{"b": "b"})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Name != "deploy" {
+		t.Fatalf("expected only deploy to trigger, got %+v", evs)
+	}
+}
+
+func TestCheckRepoDiagnostics(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	head := tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml":   testPipeline,
+		"pipelines/broken.yaml": testPipeline + "bogus: true\n",
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	pipelineErrors := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors:        mirrors,
+		queue:          queue,
+		pipelineErrors: pipelineErrors,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Name != "test" {
+		t.Fatalf("expected the broken pipeline to be skipped, got %+v", evs)
+	}
+
+	expected := runlet.Diagnostic{
+		Remote:  tr.dir,
+		Branch:  "master",
+		Commit:  head.String(),
+		File:    "pipelines/broken.yaml",
+		Line:    7,
+		Message: "field bogus not found in type runlet.Event",
+	}
+
+	var published runlet.Diagnostic
+	select {
+	case buf := <-pipelineErrors:
+		err := json.Unmarshal(buf, &published)
+		if err != nil {
+			t.Fatalf("got error unmarshalling diagnostic: %v", err)
+		}
+	default:
+		t.Fatal("expected a diagnostic to be published")
+	}
+
+	if published != expected {
+		t.Fatalf("expected diagnostic %+v, got %+v", expected, published)
+	}
+
+	diags := gp.Diagnostics()
+	if len(diags) != 1 || diags[0] != expected {
+		t.Fatalf("expected diagnostics %+v, got %+v", expected, diags)
+	}
+
+	// Fixing the pipeline clears the diagnostics.
+	tr.commit("fix pipeline", map[string]string{
+		"pipelines/broken.yaml": testPipeline,
+	})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if diags := gp.Diagnostics(); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics after the fix, got %+v", diags)
+	}
+}
+
+func TestCheckRepoBranchLists(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/master.yaml":   testPipeline,
+		"pipelines/any.yaml":      "steps: []\n",
+		"pipelines/releases.yaml": "branches:\n- master\n- release/*\nsteps: []\n",
+		"pipelines/others.yaml":   "branches:\n- \"!master\"\nsteps: []\n",
+		"pipelines/tags.yaml":     "tags:\n- v*\nsteps: []\n",
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	names := []string{}
+	for _, ev := range drain(t, queue) {
+		names = append(names, ev.Name)
+	}
+
+	expected := []string{"any", "master", "releases"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected pipelines %v to trigger, got %v", expected, names)
+	}
+}
+
+func TestCheckRepoTemplates(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	head := tr.commit("initial commit", map[string]string{
+		"pipelines/image.yaml": `
+branch: master
+steps:
+- name: build
+  tasks:
+  - name: image
+    arguments:
+      tag: "repo:{{ .Commit.SHA }}"
+`,
+		"pipelines/broken.yaml": `
+branch: master
+steps:
+- name: build
+  tasks:
+  - name: image
+    arguments:
+      tag: "{{ .Commit.Nope }}"
+`,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Name != "image" {
+		t.Fatalf("expected only the image pipeline to trigger, got %+v", evs)
+	}
+
+	tag := evs[0].Steps[0].Tasks[0].Arguments["tag"]
+	if tag != "repo:"+head.String() {
+		t.Fatalf("expected the tag argument to be expanded, got %v", tag)
+	}
+
+	diags := gp.Diagnostics()
+	if len(diags) != 1 || diags[0].File != "pipelines/broken.yaml" || diags[0].Pipeline != "broken" {
+		t.Fatalf("expected a diagnostic for the broken pipeline, got %+v", diags)
+	}
+}
+
+func TestCheckRepoWhen(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/main.yaml": `
+steps:
+- name: test
+- name: deploy
+  when: branch == "master" && !contains(message, "no deploy")
+`,
+		"pipelines/docs.yaml": `
+when: changed("docs/**")
+steps:
+- name: docs
+`,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	// The changes aren't known on the first check, so everything runs.
+	evs := drain(t, queue)
+	if len(evs) != 2 || evs[0].Name != "docs" || len(evs[1].Steps) != 2 {
+		t.Fatalf("expected both pipelines with every step, got %+v", evs)
+	}
+
+	tr.commit("tweak, no deploy", map[string]string{"src/main.go": "package main"})
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs = drain(t, queue)
+	if len(evs) != 1 || evs[0].Name != "main" || len(evs[0].Steps) != 1 || evs[0].Steps[0].Name != "test" {
+		t.Fatalf("expected only the test step of main, got %+v", evs)
+	}
+}
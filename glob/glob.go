@@ -0,0 +1,210 @@
+// Package glob matches slash-separated names like file paths and git
+// refs against shell-style patterns. MatchText matches free text, like
+// commit messages, against the same patterns without treating slashes
+// specially.
+//
+// Patterns are matched one segment at a time using the syntax of
+// path.Match, so "*" and "?" never match a slash. A segment that is
+// exactly "**" matches any number of segments, including none, so
+// "docs/**" matches everything under docs and "**/*.md" matches
+// Markdown files at any depth.
+package glob
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Match reports whether name matches pattern. Malformed patterns never
+// match; use Validate to check a pattern up front.
+func Match(pattern, name string) bool {
+	return match(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse runs of "**" since they mean the same thing as one.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := range name {
+				if match(pattern, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// MatchAny reports whether name matches at least one of patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate returns an error if pattern is malformed.
+func Validate(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsPattern reports whether s has any special characters in it, as
+// opposed to being a literal name.
+func IsPattern(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// MatchText reports whether text matches pattern, where text is free
+// text like a commit message or an author's name rather than a
+// slash-separated name. The syntax is that of path.Match, except that
+// "*" and "?" match slashes and newlines too, and "**" is just "*"
+// twice. Malformed patterns never match; use ValidateText to check a
+// pattern up front.
+func MatchText(pattern, text string) bool {
+	re, err := textRegexp(pattern)
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(text)
+}
+
+// ValidateText returns an error if pattern is malformed for MatchText.
+func ValidateText(pattern string) error {
+	_, err := textRegexp(pattern)
+	return err
+}
+
+// textRegexp translates a pattern for MatchText into a regexp that
+// matches the whole text.
+func textRegexp(pattern string) (*regexp.Regexp, error) {
+	src := []rune(pattern)
+
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			i++
+			if i == len(src) {
+				return nil, path.ErrBadPattern
+			}
+
+			b.WriteString(regexp.QuoteMeta(string(src[i])))
+		case '[':
+			n, err := writeClass(&b, src[i+1:])
+			if err != nil {
+				return nil, err
+			}
+
+			i += n
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString(`$`)
+
+	return regexp.Compile(b.String())
+}
+
+// writeClass translates the character class at the start of src, just
+// after its "[", and returns how many runes it took up, up to and
+// including the closing "]".
+func writeClass(b *strings.Builder, src []rune) (int, error) {
+	i := 0
+	b.WriteString(`[`)
+
+	if i < len(src) && src[i] == '^' {
+		b.WriteString(`^`)
+		i++
+	}
+
+	// next reads one character of a range, which may be escaped.
+	next := func() (rune, error) {
+		if i == len(src) || src[i] == '-' || src[i] == ']' {
+			return 0, path.ErrBadPattern
+		}
+
+		if src[i] == '\\' {
+			i++
+			if i == len(src) {
+				return 0, path.ErrBadPattern
+			}
+		}
+
+		c := src[i]
+		i++
+
+		return c, nil
+	}
+
+	empty := true
+	for {
+		if i == len(src) {
+			return 0, path.ErrBadPattern
+		}
+
+		if src[i] == ']' && !empty {
+			b.WriteString(`]`)
+			return i + 1, nil
+		}
+
+		lo, err := next()
+		if err != nil {
+			return 0, err
+		}
+
+		hi := lo
+		if i < len(src) && src[i] == '-' {
+			i++
+			hi, err = next()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		fmt.Fprintf(b, `\x{%x}-\x{%x}`, lo, hi)
+		empty = false
+	}
+}
@@ -0,0 +1,86 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"master", "master", true},
+		{"master", "main", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"release/*", "release", false},
+		{"feature/**", "feature/a", true},
+		{"feature/**", "feature/a/b/c", true},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/intro.md", true},
+		{"**/*.md", "docs/guide/intro.go", false},
+		{"docs/**/*.png", "docs/img.png", true},
+		{"docs/**/*.png", "docs/a/b/img.png", true},
+		{"src/**", "docs/a", false},
+		{"v?.*", "v1.0", true},
+		{"v[0-9]*", "v10", true},
+		{"v[0-9]*", "vx", false},
+		{"[", "[", false},
+	}
+
+	for _, test := range tests {
+		if actual := Match(test.pattern, test.name); actual != test.expected {
+			t.Errorf("expected Match(%q, %q) to be %v, got %v", test.pattern, test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("release/**/v[0-9]*"); err != nil {
+		t.Fatalf("expected valid pattern, got %v", err)
+	}
+
+	if err := Validate("release/[0-9"); err == nil {
+		t.Fatal("expected malformed pattern to fail validation")
+	}
+}
+
+func TestMatchText(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		text     string
+		expected bool
+	}{
+		{"wip*", "wip: fix api/handler", true},
+		{"*deploy*", "deploy\n\nsee docs/x", true},
+		{"*deploy*", "fix docs/x", false},
+		{"wip?", "wip/", true},
+		{"a.b", "axb", false},
+		{"[!]*", "!x", true},
+		{"[^a-c]*", "d/e", true},
+		{"[^a-c]*", "b/e", false},
+		{`\*literal`, "*literal", true},
+		{`\*literal`, "xliteral", false},
+		{"(x)+", "(x)+", true},
+		{"[", "[", false},
+	}
+
+	for _, test := range tests {
+		if actual := MatchText(test.pattern, test.text); actual != test.expected {
+			t.Errorf("expected MatchText(%q, %q) to be %v, got %v", test.pattern, test.text, test.expected, actual)
+		}
+	}
+}
+
+func TestValidateText(t *testing.T) {
+	for _, pattern := range []string{"*", "wip*", "[a-z]?", `\[`} {
+		if err := ValidateText(pattern); err != nil {
+			t.Fatalf("expected %q to be valid, got %v", pattern, err)
+		}
+	}
+
+	for _, pattern := range []string{"[", "[]", "[z-a]", `trailing\`, "[a-"} {
+		if err := ValidateText(pattern); err == nil {
+			t.Fatalf("expected %q to fail validation", pattern)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/run-ci/git-poller/runlet"
+)
+
+// diagnoser is a poller that keeps track of problems with the pipelines
+// it's read.
+type diagnoser interface {
+	Diagnostics() []runlet.Diagnostic
+}
+
+type diagnosticsResponse struct {
+	pollerResponse
+
+	Diagnostics []runlet.Diagnostic `json:"diagnostics"`
+}
+
+func (srv *Server) getDiagnostics(rw http.ResponseWriter, req *http.Request) {
+	reqid := req.Context().Value(keyReqID).(string)
+	logger := logger.WithField("request_id", reqid)
+
+	logger.Debug("begin getting diagnostics")
+	keys := srv.pool.GetPollers()
+	sort.Strings(keys)
+
+	resp := []diagnosticsResponse{}
+	for _, key := range keys {
+		// The poller may have been deleted since the keys were listed.
+		plr, ok := srv.pool.GetPoller(key)
+		if !ok {
+			continue
+		}
+
+		d, ok := plr.(diagnoser)
+		if !ok {
+			continue
+		}
+
+		resp = append(resp, diagnosticsResponse{
+			pollerResponse: pollerFromKey(key),
+			Diagnostics:    d.Diagnostics(),
+		})
+	}
+	logger.Debug("done getting diagnostics")
+
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		logger.WithError(err).Error("unable to marshal response")
+
+		rw.WriteHeader(http.StatusInternalServerError)
+		buf, err = json.Marshal(map[string]string{
+			"error": err.Error(),
+		})
+		if err != nil {
+			logger.WithField("marshal_err", err).
+				Error("unable to marshal error response")
+
+			return
+		}
+		rw.Write(buf)
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(buf)
+}
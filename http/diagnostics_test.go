@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/run-ci/git-poller/async"
+	"github.com/run-ci/git-poller/runlet"
+)
+
+type testDiagnoser struct {
+	testPoller
+
+	diags []runlet.Diagnostic
+}
+
+func (td *testDiagnoser) Diagnostics() []runlet.Diagnostic {
+	return td.diags
+}
+
+func TestGetDiagnostics(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://test/diagnostics", nil)
+	rw := httptest.NewRecorder()
+
+	req = req.WithContext(context.WithValue(context.Background(), keyReqID, "test"))
+
+	pool := async.NewPool()
+	go func() {
+		_ = pool.Run()
+	}()
+
+	block := func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	plr := &testDiagnoser{
+		diags: []runlet.Diagnostic{
+			{
+				Remote:  "repo",
+				Branch:  "master",
+				Commit:  "abc123",
+				File:    "pipelines/test.yaml",
+				Line:    3,
+				Message: "line 3: field bogus not found in type runlet.Event",
+			},
+		},
+	}
+	plr.pollfn = block
+	pool.AddPoller("repo#master", plr)
+
+	// Pollers that don't keep diagnostics are left out.
+	pool.AddPoller("repo#develop", &testPoller{pollfn: block})
+
+	srv := NewServer("test:80", pool)
+	srv.getDiagnostics(rw, req)
+
+	resp := rw.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("got error reading response body: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := []diagnosticsResponse{}
+	err = json.Unmarshal(buf, &body)
+	if err != nil {
+		t.Fatalf("got error unmarshaling response body: %v", err)
+	}
+
+	if len(body) != 1 {
+		t.Fatalf("expected diagnostics for one poller, got %v", len(body))
+	}
+
+	if body[0].Remote != "repo" || body[0].Branch != "master" {
+		t.Fatalf("expected diagnostics for repo#master, got %+v", body[0].pollerResponse)
+	}
+
+	if len(body[0].Diagnostics) != 1 || body[0].Diagnostics[0] != plr.diags[0] {
+		t.Fatalf("expected %+v, got %+v", plr.diags, body[0].Diagnostics)
+	}
+}
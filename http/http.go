@@ -49,6 +49,9 @@ func NewServer(addr string, pool *async.Pool) *Server {
 	r.Handle("/pollers", chain(srv.getPollers, setRequestID, logRequest)).
 		Methods(http.MethodGet)
 
+	r.Handle("/diagnostics", chain(srv.getDiagnostics, setRequestID, logRequest)).
+		Methods(http.MethodGet)
+
 	return srv
 }
 
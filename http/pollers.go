@@ -4,11 +4,55 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type pollerResponse struct {
 	Remote string `json:"remote"`
-	Branch string `json:"branch"`
+	Branch string `json:"branch,omitempty"`
+	Tags   string `json:"tags,omitempty"`
+}
+
+// intervaler is a poller that checks its remote on an interval, which
+// may change while it runs.
+type intervaler interface {
+	Interval() time.Duration
+}
+
+// breakerer is a poller with a circuit breaker that stops it from
+// hammering a remote that keeps failing.
+type breakerer interface {
+	Breaker() (state string, failures int)
+}
+
+type pollerListing struct {
+	pollerResponse
+
+	Interval string `json:"interval,omitempty"`
+	Breaker  string `json:"breaker,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+const tagRefPrefix = "refs/tags/"
+
+// pollerFromKey splits a poller's key in the pool back up into what
+// it's watching.
+func pollerFromKey(key string) pollerResponse {
+	tup := strings.SplitN(key, "#", 2)
+
+	resp := pollerResponse{
+		Remote: tup[0],
+	}
+
+	// Tag pollers are keyed on the ref pattern they're watching, since
+	// that can't be mistaken for a branch.
+	if strings.HasPrefix(tup[1], tagRefPrefix) {
+		resp.Tags = strings.TrimPrefix(tup[1], tagRefPrefix)
+	} else {
+		resp.Branch = tup[1]
+	}
+
+	return resp
 }
 
 func (srv *Server) getPollers(rw http.ResponseWriter, req *http.Request) {
@@ -16,18 +60,27 @@ func (srv *Server) getPollers(rw http.ResponseWriter, req *http.Request) {
 	logger := logger.WithField("request_id", reqid)
 
 	logger.Debug("begin getting pollers")
-	plrs := srv.pool.GetPollers()
-	logger.Debug("done getting pollers")
+	keys := srv.pool.GetPollers()
 
-	resp := make([]pollerResponse, len(plrs))
-	for i, plr := range plrs {
-		tup := strings.Split(plr, "#")
+	resp := make([]pollerListing, len(keys))
+	for i, key := range keys {
+		resp[i].pollerResponse = pollerFromKey(key)
 
-		resp[i] = pollerResponse{
-			Remote: tup[0],
-			Branch: tup[1],
+		// The poller may have been deleted since the keys were listed.
+		plr, ok := srv.pool.GetPoller(key)
+		if !ok {
+			continue
+		}
+
+		if iv, ok := plr.(intervaler); ok {
+			resp[i].Interval = iv.Interval().String()
+		}
+
+		if b, ok := plr.(breakerer); ok {
+			resp[i].Breaker, resp[i].Failures = b.Breaker()
 		}
 	}
+	logger.Debug("done getting pollers")
 
 	buf, err := json.Marshal(resp)
 	if err != nil {
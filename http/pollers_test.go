@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/run-ci/git-poller/async"
 )
@@ -84,3 +85,56 @@ func TestGetPollers(t *testing.T) {
 		t.Fatalf(`expected "branch" to be set to "master"; got %v`, branch)
 	}
 }
+
+type testIntervaler struct {
+	testPoller
+
+	interval time.Duration
+}
+
+func (ti *testIntervaler) Interval() time.Duration {
+	return ti.interval
+}
+
+func (ti *testIntervaler) Breaker() (string, int) {
+	return "open", 5
+}
+
+func TestGetPollersStatus(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://test/pollers", nil)
+	rw := httptest.NewRecorder()
+
+	req = req.WithContext(context.WithValue(context.Background(), keyReqID, "test"))
+
+	pool := async.NewPool()
+	go func() {
+		_ = pool.Run()
+	}()
+
+	plr := &testIntervaler{interval: 90 * time.Second}
+	plr.pollfn = func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+	pool.AddPoller("repo#master", plr)
+
+	srv := NewServer("test:80", pool)
+	srv.getPollers(rw, req)
+
+	resp := rw.Result()
+	defer resp.Body.Close()
+
+	body := []map[string]interface{}{}
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("got error unmarshaling response body: %v", err)
+	}
+
+	if len(body) != 1 || body[0]["interval"] != "1m30s" {
+		t.Fatalf(`expected "interval" to be set to "1m30s", got %v`, body)
+	}
+
+	if body[0]["breaker"] != "open" || body[0]["failures"] != float64(5) {
+		t.Fatalf(`expected an "open" breaker with 5 failures, got %v`, body[0])
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/run-ci/git-poller/runlet"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fragment is a file of steps or tasks that pipelines can include, so
+// they don't all have to repeat them. Fragments that are included by a
+// pipeline define steps, and fragments that are included by a step
+// define tasks. Either kind can include more fragments of its kind.
+type fragment struct {
+	Include []string      `yaml:"include"`
+	Steps   []runlet.Step `yaml:"steps"`
+	Tasks   []runlet.Task `yaml:"tasks"`
+}
+
+// includer resolves includes against the files in a source. Fragments
+// are only parsed once, no matter how many pipelines include them.
+type includer struct {
+	src       source
+	fragments map[string]fragment
+}
+
+func newIncluder(src source) *includer {
+	return &includer{
+		src:       src,
+		fragments: map[string]fragment{},
+	}
+}
+
+// resolve replaces the includes in the pipeline read from file with
+// what they include. Errors are pipelineErrors for the file the problem
+// is in, which may be an included file rather than the pipeline's.
+func (inc *includer) resolve(ev *runlet.Event, file string) error {
+	steps, err := inc.steps(ev.Include, ev.Steps, []string{file})
+	if err != nil {
+		return err
+	}
+
+	ev.Include = nil
+	ev.Steps = steps
+
+	return nil
+}
+
+// steps returns the steps of the included fragments followed by steps,
+// with the includes in all of them resolved. chain is the files that
+// led here, starting with the pipeline.
+func (inc *includer) steps(includes []string, steps []runlet.Step, chain []string) ([]runlet.Step, error) {
+	out := []runlet.Step{}
+	for _, p := range includes {
+		frag, err := inc.load(p, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(frag.Tasks) > 0 {
+			return nil, pipelineError{
+				path: p,
+				err:  errors.New("fragment defines tasks, which can only be included by a step"),
+			}
+		}
+
+		included, err := inc.steps(frag.Include, frag.Steps, append(chain, p))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, included...)
+	}
+
+	for _, step := range steps {
+		tasks, err := inc.tasks(step.Include, step.Tasks, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		step.Include = nil
+		step.Tasks = tasks
+		out = append(out, step)
+	}
+
+	return out, nil
+}
+
+// tasks returns the tasks of the included fragments followed by tasks.
+func (inc *includer) tasks(includes []string, tasks []runlet.Task, chain []string) ([]runlet.Task, error) {
+	if len(includes) == 0 {
+		return tasks, nil
+	}
+
+	out := []runlet.Task{}
+	for _, p := range includes {
+		frag, err := inc.load(p, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(frag.Steps) > 0 {
+			return nil, pipelineError{
+				path: p,
+				err:  errors.New("fragment defines steps, which can only be included by a pipeline"),
+			}
+		}
+
+		included, err := inc.tasks(frag.Include, frag.Tasks, append(chain, p))
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, included...)
+	}
+
+	return append(out, tasks...), nil
+}
+
+// load reads the fragment at p, which is included by the last file in
+// chain.
+func (inc *includer) load(p string, chain []string) (fragment, error) {
+	includedBy := chain[len(chain)-1]
+
+	if !insideRepo(p) {
+		return fragment{}, pipelineError{
+			path: includedBy,
+			err:  fmt.Errorf("unable to include %v, it's outside of the repo", p),
+		}
+	}
+	p = path.Clean(p)
+
+	if contains(chain, p) {
+		return fragment{}, pipelineError{
+			path: includedBy,
+			err:  fmt.Errorf("include cycle: %v", strings.Join(append(chain, p), " -> ")),
+		}
+	}
+
+	if frag, ok := inc.fragments[p]; ok {
+		return frag, nil
+	}
+
+	buf, err := inc.src.ReadFile(p)
+	if os.IsNotExist(err) {
+		return fragment{}, pipelineError{
+			path: includedBy,
+			err:  fmt.Errorf("unable to include %v, it doesn't exist", p),
+		}
+	}
+	if err != nil {
+		return fragment{}, pipelineError{
+			path: includedBy,
+			err:  fmt.Errorf("unable to include %v: %v", p, err),
+		}
+	}
+
+	var frag fragment
+	err = yaml.UnmarshalStrict(buf, &frag)
+	if err != nil {
+		return fragment{}, pipelineError{path: p, err: err}
+	}
+
+	inc.fragments[p] = frag
+
+	return frag, nil
+}
+
+// insideRepo reports whether the slash-separated path p stays inside
+// the root of the repo.
+func insideRepo(p string) bool {
+	p = path.Clean(p)
+
+	return !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// intervals are the service-wide limits on how often pollers check
+// their remotes.
+type intervals struct {
+	// Default is the interval of pollers that don't ask for one.
+	Default time.Duration
+	// Min and Max bound the intervals pollers can ask for.
+	Min time.Duration
+	Max time.Duration
+}
+
+// defaultIntervals are used unless they're overridden in the
+// environment.
+var defaultIntervals = intervals{
+	Default: 1 * time.Minute,
+	Min:     10 * time.Second,
+	Max:     1 * time.Hour,
+}
+
+// validate returns an error if the limits contradict each other.
+func (iv intervals) validate() error {
+	if iv.Min <= 0 {
+		return fmt.Errorf("minimum interval %v isn't positive", iv.Min)
+	}
+
+	if iv.Min > iv.Max {
+		return fmt.Errorf("minimum interval %v is more than the maximum %v", iv.Min, iv.Max)
+	}
+
+	if iv.Default < iv.Min || iv.Default > iv.Max {
+		return fmt.Errorf("default interval %v isn't between %v and %v", iv.Default, iv.Min, iv.Max)
+	}
+
+	return nil
+}
+
+// parse parses the interval a poller asked for, like "30s" or "1h".
+// Pollers that don't ask get the default, and intervals out of bounds
+// are moved to the nearest bound.
+func (iv intervals) parse(raw string) (time.Duration, error) {
+	if raw == "" {
+		return iv.Default, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %v: %v", raw, err)
+	}
+
+	return iv.clamp(d), nil
+}
+
+// bounds parses the bounds a poller asked for, which have to be within
+// the service-wide ones. Pollers that don't ask get the service-wide
+// bounds, and the default interval is moved within the poller's.
+func (iv intervals) bounds(rawMin, rawMax string) (intervals, error) {
+	b := iv
+
+	for _, bound := range []struct {
+		name string
+		raw  string
+		d    *time.Duration
+	}{
+		{name: "minimum", raw: rawMin, d: &b.Min},
+		{name: "maximum", raw: rawMax, d: &b.Max},
+	} {
+		if bound.raw == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(bound.raw)
+		if err != nil {
+			return intervals{}, fmt.Errorf("invalid %v interval %v: %v", bound.name, bound.raw, err)
+		}
+
+		*bound.d = iv.clamp(d)
+	}
+
+	if b.Min > b.Max {
+		return intervals{}, fmt.Errorf("minimum interval %v is more than the maximum %v", b.Min, b.Max)
+	}
+
+	b.Default = b.clamp(b.Default)
+
+	return b, nil
+}
+
+// clamp moves d to the nearest bound if it's out of bounds.
+func (iv intervals) clamp(d time.Duration) time.Duration {
+	if d < iv.Min {
+		return iv.Min
+	}
+
+	if d > iv.Max {
+		return iv.Max
+	}
+
+	return d
+}
+
+// backoff is how much the interval of an adaptive poller grows after
+// each check that finds nothing new.
+const backoff = 1.5
+
+// Interval returns how long the poller currently waits between checks.
+func (gp *gitPoller) Interval() time.Duration {
+	gp.intervalMu.Lock()
+	defer gp.intervalMu.Unlock()
+
+	if gp.interval <= 0 {
+		gp.interval = defaultIntervals.Default
+	}
+
+	return gp.interval
+}
+
+// adapt works out the interval until the next check. Adaptive pollers
+// drop to their minimum interval as soon as a head changes, since more
+// changes tend to follow, then back off gradually towards their maximum
+// while the repo is quiet. Other pollers keep the interval they have.
+func (gp *gitPoller) adapt(changed bool) time.Duration {
+	gp.intervalMu.Lock()
+	defer gp.intervalMu.Unlock()
+
+	if !gp.adaptive {
+		return gp.interval
+	}
+
+	if changed {
+		gp.interval = gp.bounds.Min
+	} else {
+		gp.interval = gp.bounds.clamp(time.Duration(float64(gp.interval) * backoff))
+	}
+
+	return gp.interval
+}
+
+// jitter picks how long a poller waits before its first check, so that
+// pollers created together don't all hit their git hosts at once.
+var jitter = func(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(interval)))
+}
+
+// sleep waits for d to pass. It returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIntervalsParse(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Duration
+	}{
+		{raw: "", expected: time.Minute},
+		{raw: "30s", expected: 30 * time.Second},
+		{raw: "1s", expected: 10 * time.Second},
+		{raw: "24h", expected: time.Hour},
+	}
+
+	for _, test := range tests {
+		d, err := defaultIntervals.parse(test.raw)
+		if err != nil {
+			t.Fatalf("got error parsing %q: %v", test.raw, err)
+		}
+
+		if d != test.expected {
+			t.Fatalf("expected %q to give %v, got %v", test.raw, test.expected, d)
+		}
+	}
+
+	_, err := defaultIntervals.parse("soon")
+	if err == nil {
+		t.Fatal("expected an error parsing an invalid interval")
+	}
+}
+
+func TestIntervalsValidate(t *testing.T) {
+	err := defaultIntervals.validate()
+	if err != nil {
+		t.Fatalf("expected the default intervals to be valid, got %v", err)
+	}
+
+	for _, iv := range []intervals{
+		{Default: time.Minute, Min: 0, Max: time.Hour},
+		{Default: time.Minute, Min: time.Hour, Max: time.Second},
+		{Default: 2 * time.Hour, Min: time.Second, Max: time.Hour},
+	} {
+		err := iv.validate()
+		if err == nil {
+			t.Fatalf("expected %+v to be invalid", iv)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		if d < 0 || d >= time.Second {
+			t.Fatalf("expected jitter within the interval, got %v", d)
+		}
+	}
+}
+
+func TestPollStopsWhenDone(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	gp := &gitPoller{
+		remote:   tr.dir,
+		branch:   "master",
+		interval: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- gp.Poll(ctx)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the poller to stop cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the poller to stop while waiting for its next check")
+	}
+}
+
+func TestIntervalsBounds(t *testing.T) {
+	b, err := defaultIntervals.bounds("30s", "5m")
+	if err != nil {
+		t.Fatalf("got error parsing bounds: %v", err)
+	}
+
+	expected := intervals{Default: time.Minute, Min: 30 * time.Second, Max: 5 * time.Minute}
+	if b != expected {
+		t.Fatalf("expected bounds %+v, got %+v", expected, b)
+	}
+
+	// Bounds can't go past the service-wide ones, and the default moves
+	// within them.
+	b, err = defaultIntervals.bounds("1s", "")
+	if err != nil || b.Min != 10*time.Second || b.Max != time.Hour {
+		t.Fatalf("expected the service-wide bounds, got %+v, %v", b, err)
+	}
+
+	b, err = defaultIntervals.bounds("5m", "")
+	if err != nil || b.Default != 5*time.Minute {
+		t.Fatalf("expected the default to move up to the minimum, got %+v, %v", b, err)
+	}
+
+	for _, raw := range [][2]string{{"10m", "5m"}, {"soon", ""}, {"", "later"}} {
+		_, err := defaultIntervals.bounds(raw[0], raw[1])
+		if err == nil {
+			t.Fatalf("expected bounds %v to be invalid", raw)
+		}
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	gp := &gitPoller{
+		interval: time.Minute,
+		adaptive: true,
+		bounds:   intervals{Min: 10 * time.Second, Max: 2 * time.Minute},
+	}
+
+	if d := gp.adapt(false); d != 90*time.Second {
+		t.Fatalf("expected a quiet repo to back off to 1m30s, got %v", d)
+	}
+
+	if d := gp.adapt(false); d != 2*time.Minute {
+		t.Fatalf("expected the back off to stop at the maximum, got %v", d)
+	}
+
+	if d := gp.adapt(true); d != 10*time.Second || gp.Interval() != d {
+		t.Fatalf("expected a change to drop to the minimum, got %v", d)
+	}
+
+	if d := gp.adapt(false); d != 15*time.Second {
+		t.Fatalf("expected the back off to start over, got %v", d)
+	}
+
+	gp = &gitPoller{interval: time.Minute}
+	if d := gp.adapt(true); d != time.Minute {
+		t.Fatalf("expected a fixed interval to stay put, got %v", d)
+	}
+}
+
+func TestCheckRepoCountsChanges(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml": testPipeline,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	gp := &gitPoller{
+		remote: tr.dir,
+		branch: "master",
+
+		mirrors: mirrors,
+		queue:   make(chan []byte, 16),
+	}
+
+	// Finding the heads for the first time isn't a change.
+	for i := 0; i < 2; i++ {
+		err := gp.checkRepo()
+		if err != nil {
+			t.Fatalf("got error checking repo: %v", err)
+		}
+	}
+
+	if gp.changes != 0 {
+		t.Fatalf("expected no changes yet, got %v", gp.changes)
+	}
+
+	tr.commit("second commit", map[string]string{
+		"README.md": "hello",
+	})
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if gp.changes != 1 {
+		t.Fatalf("expected one change, got %v", gp.changes)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+const (
+	lifecycleBranchCreated = "branch-created"
+	lifecycleBranchDeleted = "branch-deleted"
+	// Pollers are degraded when their circuit breaker opens, and recover
+	// when it closes again.
+	lifecycleDegraded  = "degraded"
+	lifecycleRecovered = "recovered"
+	// Pollers are suspended as soon as they hit an error that won't go
+	// away by itself, and resumed once it's been fixed.
+	lifecycleSuspended = "suspended"
+	lifecycleResumed   = "resumed"
+)
+
+// lifecycleEvent announces a change to what a poller is watching, as
+// opposed to a change in the code it's watching.
+type lifecycleEvent struct {
+	Type   string `json:"type"`
+	Remote string `json:"remote"`
+	// Poller is the branch or pattern the poller was created with, or
+	// "refs/tags/" and the pattern for tag pollers, like in the pool's
+	// keys.
+	Poller string `json:"poller"`
+	Branch string `json:"branch,omitempty"`
+	Head   string `json:"head,omitempty"`
+	// Kind is the kind of error that degraded or suspended the poller.
+	Kind  string `json:"kind,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// publishLifecycle queues up the lifecycle event, if the poller has
+// somewhere to send them.
+func (gp *gitPoller) publishLifecycle(ev lifecycleEvent) {
+	if gp.lifecycle == nil {
+		return
+	}
+
+	ev.Remote = gp.remote
+	ev.Poller = gp.ref()
+
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		logger.WithError(err).WithField("event", ev).
+			Error("unable to marshal lifecycle event")
+
+		return
+	}
+
+	gp.lifecycle <- buf
+}
+
+// ref is what the poller watches, in the form it's keyed on in the
+// pool.
+func (gp *gitPoller) ref() string {
+	if gp.tags != "" {
+		return tagRefPrefix + gp.tags
+	}
+
+	return gp.branch
+}
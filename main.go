@@ -1,19 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	nats "github.com/nats-io/go-nats"
 	"github.com/run-ci/git-poller/async"
+	"github.com/run-ci/git-poller/credentials"
+	"github.com/run-ci/git-poller/glob"
 	"github.com/run-ci/git-poller/http"
+	"github.com/run-ci/git-poller/mirror"
 	"github.com/run-ci/git-poller/queue"
+	"github.com/run-ci/git-poller/throttle"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
 
 var logger *logrus.Entry
 var natsURL string
+var workDir string
+var cacheMaxBytes int64
+var credentialsFile string
+var pollIntervals intervals
+var breakerThreshold int
+var maxBackoff time.Duration
+var maxGitOps int
+var hostLimits map[string]throttle.HostLimit
 
 func init() {
 	lvl, err := logrus.ParseLevel(os.Getenv("POLLER_LOG_LEVEL"))
@@ -24,15 +42,95 @@ func init() {
 	logrus.SetLevel(lvl)
 
 	logger = logrus.WithField("package", "main")
+}
+
+// loadConfig reads the server's configuration from the environment.
+func loadConfig() {
+	var err error
 
 	natsURL = os.Getenv("POLLER_NATS_URL")
 	if natsURL == "" {
 		logger.Infof("no nats url specified, defaulting to %v", nats.DefaultURL)
 		natsURL = nats.DefaultURL
 	}
+
+	workDir = os.Getenv("POLLER_WORK_DIR")
+	if workDir == "" {
+		workDir = "/tmp/git-poller"
+		logger.Infof("no work dir specified, defaulting to %v", workDir)
+	}
+
+	credentialsFile = os.Getenv("POLLER_CREDENTIALS_FILE")
+
+	cacheMaxBytes = 5 << 30
+	if raw := os.Getenv("POLLER_CACHE_MAX_BYTES"); raw != "" {
+		cacheMaxBytes, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			logger.WithError(err).Fatal("invalid POLLER_CACHE_MAX_BYTES")
+		}
+	}
+
+	pollIntervals = defaultIntervals
+	for name, d := range map[string]*time.Duration{
+		"POLLER_DEFAULT_INTERVAL": &pollIntervals.Default,
+		"POLLER_MIN_INTERVAL":     &pollIntervals.Min,
+		"POLLER_MAX_INTERVAL":     &pollIntervals.Max,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			*d, err = time.ParseDuration(raw)
+			if err != nil {
+				logger.WithError(err).Fatalf("invalid %v", name)
+			}
+		}
+	}
+
+	err = pollIntervals.validate()
+	if err != nil {
+		logger.WithError(err).Fatal("invalid poll intervals")
+	}
+
+	breakerThreshold = defaultBreakerThreshold
+	if raw := os.Getenv("POLLER_BREAKER_THRESHOLD"); raw != "" {
+		breakerThreshold, err = strconv.Atoi(raw)
+		if err != nil || breakerThreshold < 1 {
+			logger.WithField("value", raw).Fatal("invalid POLLER_BREAKER_THRESHOLD")
+		}
+	}
+
+	maxBackoff = defaultMaxBackoff
+	if raw := os.Getenv("POLLER_MAX_BACKOFF"); raw != "" {
+		maxBackoff, err = time.ParseDuration(raw)
+		if err != nil || maxBackoff <= 0 {
+			logger.WithField("value", raw).Fatal("invalid POLLER_MAX_BACKOFF")
+		}
+	}
+
+	maxGitOps = 16
+	if raw := os.Getenv("POLLER_MAX_GIT_OPS"); raw != "" {
+		maxGitOps, err = strconv.Atoi(raw)
+		if err != nil {
+			logger.WithError(err).Fatal("invalid POLLER_MAX_GIT_OPS")
+		}
+	}
+
+	hostLimits, err = throttle.ParseHostLimits(os.Getenv("POLLER_HOST_LIMITS"))
+	if err != nil {
+		logger.WithError(err).Fatal("invalid POLLER_HOST_LIMITS")
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	serve()
+}
+
+// serve runs the poller server until it's killed.
+func serve() {
+	loadConfig()
+
 	logger.Info("booting server...")
 
 	logger.Info("creating async pool")
@@ -45,6 +143,26 @@ func main() {
 		}
 	}()
 
+	creds := &credentials.Store{}
+	if credentialsFile != "" {
+		logger.Info("loading credentials")
+
+		var err error
+		creds, err = credentials.Load(credentialsFile)
+		if err != nil {
+			logger.WithError(err).Fatal("unable to load credentials, shutting down")
+		}
+	}
+
+	logger.Info("creating mirror cache")
+
+	mirrors, err := mirror.NewCache(filepath.Join(workDir, "mirrors"), cacheMaxBytes)
+	if err != nil {
+		logger.WithError(err).Fatal("unable to create mirror cache, shutting down")
+	}
+
+	limiter := throttle.New(maxGitOps, hostLimits)
+
 	logger.Info("creating NATS bus")
 
 	bus, err := queue.NewNATS(natsURL)
@@ -59,6 +177,12 @@ func main() {
 	logger.Info("creating send queue for pipelines")
 	send := bus.SenderOn("pipelines")
 
+	logger.Info("creating send queue for poller lifecycle events")
+	lifecycle := bus.SenderOn("poller-events")
+
+	logger.Info("creating send queue for pipeline errors")
+	pipelineErrors := bus.SenderOn("pipeline-errors")
+
 	logger.Info("creating listen queue for pollers")
 	recv, err := bus.ListenerOn("pollers")
 	if err != nil {
@@ -83,17 +207,82 @@ func main() {
 		logger := logger.WithFields(logrus.Fields{
 			"remote": msg.Remote,
 			"branch": msg.Branch,
+			"tags":   msg.Tags,
 			"op":     msg.Op,
 		})
 		logger.Info("creating git poller")
 
+		if msg.Branch != "" && msg.Tags != "" {
+			return errors.New("a poller can watch a branch or tags, not both")
+		}
+
+		if err := glob.Validate(msg.Branch); err != nil {
+			return fmt.Errorf("invalid branch pattern %v: %v", msg.Branch, err)
+		}
+
+		tags := strings.TrimPrefix(msg.Tags, tagRefPrefix)
+		if err := glob.Validate(tags); err != nil {
+			return fmt.Errorf("invalid tags pattern %v: %v", msg.Tags, err)
+		}
+
+		var auth transport.AuthMethod
+		if msg.Credential != "" {
+			var err error
+			auth, err = creds.Get(msg.Credential)
+			if err != nil {
+				return fmt.Errorf("unable to get credential %v: %v", msg.Credential, err)
+			}
+		}
+
+		err := msg.Pipelines.validate()
+		if err != nil {
+			return err
+		}
+
+		bounds, err := pollIntervals.bounds(msg.MinInterval, msg.MaxInterval)
+		if err != nil {
+			return err
+		}
+
+		interval, err := bounds.parse(msg.Interval)
+		if err != nil {
+			return err
+		}
+
+		mode := msg.Mode
+		switch mode {
+		case "":
+			mode = modeLatest
+		case modeLatest, modeEvery, modeBatched:
+		default:
+			return fmt.Errorf("unknown mode %v", mode)
+		}
+
 		gp := &gitPoller{
 			remote: msg.Remote,
 			branch: msg.Branch,
-			queue:  send,
+			mode:   mode,
+			tags:   tags,
+			auth:   auth,
+
+			interval:  interval,
+			adaptive:  msg.Adaptive,
+			bounds:    bounds,
+			discovery: msg.Pipelines,
+
+			breaker: breaker{
+				threshold:  breakerThreshold,
+				maxBackoff: maxBackoff,
+			},
+
+			limiter:        limiter,
+			mirrors:        mirrors,
+			queue:          send,
+			lifecycle:      lifecycle,
+			pipelineErrors: pipelineErrors,
 		}
 
-		pool.AddPoller(fmt.Sprintf("%v#%v", gp.remote, gp.branch), gp)
+		pool.AddPoller(msg.key(), gp)
 
 		return nil
 	})
@@ -102,12 +291,12 @@ func main() {
 		logger := logger.WithFields(logrus.Fields{
 			"remote": msg.Remote,
 			"branch": msg.Branch,
+			"tags":   msg.Tags,
 			"op":     msg.Op,
 		})
 		logger.Info("deleting git poller")
 
-		key := fmt.Sprintf("%v#%v", msg.Remote, msg.Branch)
-		pool.DeletePoller(key)
+		pool.DeletePoller(msg.key())
 
 		return nil
 	})
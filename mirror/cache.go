@@ -0,0 +1,451 @@
+package mirror
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// refSpecs are the refs that get mirrored from every remote. Everything
+// is force-updated so that the mirror always matches the remote, even
+// after history gets rewritten.
+var refSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Cache is a set of bare mirrors of remote repositories kept on local
+// disk, keyed by normalized remote URL. Mirrors are fetched incrementally
+// so only new objects are downloaded when a remote changes. When the
+// mirrors take up more than the configured size, the least recently
+// used ones that aren't being held are evicted.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu sync.Mutex
+	// Number of Mirrors handed out per key that haven't been released yet.
+	// Mirrors with holders are never evicted.
+	holders map[string]int
+	// Every key gets a lock so that a mirror is only ever fetched into or
+	// read from by one holder at a time.
+	locks map[string]*sync.Mutex
+	// The size and last use of every mirror on disk by directory name,
+	// and their total size. Sizes are updated after every fetch, so
+	// eviction never has to walk the mirrors to find out.
+	entries map[string]*entry
+	total   int64
+}
+
+// NewCache returns a Cache that keeps its mirrors in dir, creating the
+// directory if necessary. If maxBytes is 0 or less, mirrors are never
+// evicted.
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+
+		holders: make(map[string]int),
+		locks:   make(map[string]*sync.Mutex),
+		entries: make(map[string]*entry),
+	}
+
+	err = c.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// evictedSuffix marks mirror directories that were evicted but not
+// removed yet.
+const evictedSuffix = ".evicted"
+
+// scan finds the size of the mirrors that are already on disk, and
+// finishes removing the ones that were being evicted.
+func (c *Cache) scan() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, finfo := range files {
+		if !finfo.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(c.dir, finfo.Name())
+		if strings.HasSuffix(finfo.Name(), evictedSuffix) {
+			os.RemoveAll(path)
+			continue
+		}
+
+		size, err := dirsize(path)
+		if err != nil {
+			logger.WithError(err).Debugf("unable to get size of %v", path)
+			continue
+		}
+
+		c.entries[finfo.Name()] = &entry{
+			name:    finfo.Name(),
+			path:    path,
+			size:    size,
+			modtime: finfo.ModTime(),
+		}
+		c.total += size
+	}
+
+	return nil
+}
+
+// Mirror is a bare repository mirroring a remote. It's held exclusively
+// until Release is called.
+type Mirror struct {
+	*git.Repository
+
+	key   string
+	path  string
+	cache *Cache
+}
+
+// Fetch brings the mirror for the given remote up to date, creating it
+// if it doesn't exist yet, and returns it. The auth method is used to
+// fetch from the remote and may be nil. The returned Mirror must be
+// released when the caller is done reading from it.
+//
+// If limit isn't nil, the fetch from the remote is run through it, so
+// that callers can hold back network operations without also holding
+// back fetches that are only waiting for someone else to release the
+// mirror.
+func (c *Cache) Fetch(remote string, auth transport.AuthMethod, limit func(fn func() error) error) (*Mirror, error) {
+	key := Key(remote)
+	path := filepath.Join(c.dir, dirname(key))
+
+	logger := logger.WithFields(logrus.Fields{
+		"remote": remote,
+		"path":   path,
+	})
+
+	c.mu.Lock()
+	c.holders[key]++
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[key] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+
+	m := &Mirror{
+		key:   key,
+		path:  path,
+		cache: c,
+	}
+
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		logger.Info("creating mirror")
+
+		repo, err = initMirror(path, remote)
+	}
+	if err != nil {
+		logger.WithError(err).Debug("unable to open mirror")
+
+		m.Release()
+		return nil, err
+	}
+
+	logger.Debug("fetching into mirror")
+
+	if limit == nil {
+		limit = func(fn func() error) error {
+			return fn()
+		}
+	}
+
+	err = limit(func() error {
+		return repo.Fetch(&git.FetchOptions{
+			RefSpecs: refSpecs,
+			Tags:     git.NoTags,
+			Force:    true,
+			Auth:     auth,
+		})
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		logger.WithError(err).Debug("unable to fetch into mirror")
+
+		m.Release()
+		return nil, err
+	}
+
+	// Only the mirror that was fetched into can have changed size, so
+	// that's the only one that's walked.
+	if err == nil || !c.known(path) {
+		size, err := dirsize(path)
+		if err != nil {
+			logger.WithError(err).Debug("unable to get size of mirror")
+		} else {
+			c.resize(path, size)
+		}
+	}
+
+	m.Repository = repo
+	return m, nil
+}
+
+func initMirror(path, remote string) (*git.Repository, error) {
+	repo, err := git.PlainInit(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{remote},
+		Fetch: refSpecs,
+	})
+	if err != nil {
+		// Leaving a mirror without a remote behind would make every
+		// following fetch fail.
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Release gives the Mirror back to the Cache. The Mirror must not be
+// used after it's released.
+func (m *Mirror) Release() {
+	c := m.cache
+
+	// The modification time of the mirror directory is what eviction
+	// uses to tell how recently it was used.
+	now := time.Now()
+	err := os.Chtimes(m.path, now, now)
+	if err != nil && !os.IsNotExist(err) {
+		logger.WithError(err).Debugf("unable to touch mirror at %v", m.path)
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[filepath.Base(m.path)]; ok {
+		e.modtime = now
+	}
+	c.locks[m.key].Unlock()
+	c.holders[m.key]--
+	if c.holders[m.key] == 0 {
+		delete(c.holders, m.key)
+	}
+	c.mu.Unlock()
+
+	c.evict()
+}
+
+// entry is a mirror on disk.
+type entry struct {
+	name    string
+	path    string
+	size    int64
+	modtime time.Time
+}
+
+// known checks whether the size of the mirror at path is known.
+func (c *Cache) known(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.entries[filepath.Base(path)]
+	return ok
+}
+
+// resize records the size of the mirror at path after a fetch.
+func (c *Cache) resize(path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := filepath.Base(path)
+	e, ok := c.entries[name]
+	if !ok {
+		e = &entry{name: name, path: path}
+		c.entries[name] = e
+	}
+
+	c.total += size - e.size
+	e.size = size
+	e.modtime = time.Now()
+}
+
+// evict removes the least recently used mirrors that aren't held until
+// the cache fits in its size limit again. Evicted mirrors are only
+// renamed while c.mu is held, and removed from disk after it's let go,
+// so that fetches of other mirrors don't wait on the removal.
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if c.total <= c.maxBytes {
+		c.mu.Unlock()
+		return
+	}
+
+	entries := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modtime.Before(entries[j].modtime)
+	})
+
+	evicted := []string{}
+	for _, e := range entries {
+		if c.total <= c.maxBytes {
+			break
+		}
+
+		if c.isHeld(e.name) {
+			continue
+		}
+
+		logger.WithFields(logrus.Fields{
+			"path": e.path,
+			"size": e.size,
+		}).Info("evicting mirror")
+
+		trash := fmt.Sprintf("%v.%v%v", e.path, time.Now().UnixNano(), evictedSuffix)
+		err := os.Rename(e.path, trash)
+		if err != nil {
+			logger.WithError(err).Errorf("unable to evict mirror at %v", e.path)
+			continue
+		}
+
+		delete(c.entries, e.name)
+		c.total -= e.size
+		evicted = append(evicted, trash)
+	}
+	c.mu.Unlock()
+
+	for _, trash := range evicted {
+		err := os.RemoveAll(trash)
+		if err != nil {
+			logger.WithError(err).Errorf("unable to remove evicted mirror at %v", trash)
+		}
+	}
+}
+
+// isHeld checks whether the mirror in the directory with the given
+// name is currently handed out. The caller must hold c.mu.
+func (c *Cache) isHeld(name string) bool {
+	for key := range c.holders {
+		if dirname(key) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dirsize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, finfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !finfo.IsDir() {
+			size += finfo.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// dirname is the name of the directory the mirror for key lives in.
+func dirname(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%v.git", hex.EncodeToString(sum[:]))
+}
+
+// Key normalizes a remote URL so that different spellings of the same
+// remote share a mirror. The scheme and host are lowercased, default
+// ports, trailing slashes and ".git" suffixes are dropped, and scp-style
+// SSH remotes like "git@host:path" are treated as "ssh://git@host/path".
+func Key(remote string) string {
+	remote = strings.TrimSpace(remote)
+
+	if !strings.Contains(remote, "://") {
+		// scp-style remotes have a colon before the first slash. Anything
+		// else without a scheme is a local path.
+		colon := strings.Index(remote, ":")
+		slash := strings.Index(remote, "/")
+		if colon > 0 && (slash < 0 || colon < slash) {
+			remote = fmt.Sprintf("ssh://%v/%v", remote[:colon], remote[colon+1:])
+		} else {
+			return cleanPath(filepath.Clean(remote))
+		}
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil {
+		return cleanPath(remote)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	defaults := map[string]string{
+		"http":  "80",
+		"https": "443",
+		"ssh":   "22",
+		"git":   "9418",
+	}
+	if port := u.Port(); port != "" && defaults[u.Scheme] == port {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	// Credentials embedded in the URL don't change which repo it is.
+	// The SSH user does, though, since it's part of how hosts like
+	// GitHub route the connection.
+	if u.Scheme != "ssh" {
+		u.User = nil
+	}
+
+	u.Path = cleanPath(strings.TrimPrefix(u.Path, "/"))
+	if u.Path != "" {
+		u.Path = "/" + u.Path
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	return u.String()
+}
+
+func cleanPath(path string) string {
+	path = strings.TrimRight(path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	return strings.TrimRight(path, "/")
+}
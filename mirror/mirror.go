@@ -0,0 +1,11 @@
+package mirror
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+var logger *logrus.Entry
+
+func init() {
+	logger = logrus.WithField("package", "mirror")
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/run-ci/git-poller/runlet"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// discovery is how pipeline files are found in a repo.
+type discovery struct {
+	// Dir is the directory pipelines are read from, relative to the
+	// root of the repo. It defaults to "pipelines".
+	Dir string `json:"dir"`
+	// Extensions are the extensions of the files in Dir that are read
+	// as pipelines. Everything else, like READMEs, is ignored. It
+	// defaults to ".yaml", ".yml" and ".json".
+	Extensions []string `json:"extensions"`
+	// Recursive pipelines are also read from subdirectories of Dir, and
+	// named after their path, like "deploy/production".
+	Recursive bool `json:"recursive"`
+}
+
+func (d discovery) withDefaults() discovery {
+	if d.Dir == "" {
+		d.Dir = "pipelines"
+	}
+	d.Dir = path.Clean(d.Dir)
+
+	if len(d.Extensions) == 0 {
+		d.Extensions = []string{".yaml", ".yml", ".json"}
+	}
+
+	return d
+}
+
+// validate checks that the discovery settings stay inside the repo.
+func (d discovery) validate() error {
+	if !insideRepo(d.Dir) {
+		return fmt.Errorf("pipeline directory %v is outside of the repo", d.Dir)
+	}
+
+	for _, ext := range d.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("pipeline extension %v doesn't start with a dot", ext)
+		}
+	}
+
+	return nil
+}
+
+// pipeline is a pipeline read out of a repo, along with the file it
+// came from.
+type pipeline struct {
+	runlet.Event
+
+	path string
+}
+
+// pipelineError is an error reading a single pipeline file. Files with
+// errors are skipped without affecting the other pipelines.
+type pipelineError struct {
+	path string
+	err  error
+}
+
+func (pe pipelineError) Error() string {
+	return fmt.Sprintf("%v: %v", pe.path, pe.err)
+}
+
+// loadPipelines finds and parses every pipeline in src. Errors with
+// individual files are returned alongside the pipelines that could be
+// read. If the pipeline directory can't be listed at all, that error is
+// returned on its own.
+func loadPipelines(src source, d discovery) ([]pipeline, []pipelineError, error) {
+	d = d.withDefaults()
+
+	files, err := findPipelineFiles(src, d, d.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inc := newIncluder(src)
+
+	pipelines := []pipeline{}
+	errs := []pipelineError{}
+	for _, file := range files {
+		buf, err := src.ReadFile(file)
+		if err != nil {
+			errs = append(errs, pipelineError{path: file, err: err})
+			continue
+		}
+
+		evs, err := parsePipelineFile(buf)
+		if err != nil {
+			errs = append(errs, pipelineError{path: file, err: err})
+			continue
+		}
+
+		err = resolveIncludes(inc, evs, file)
+		if err != nil {
+			errs = append(errs, err.(pipelineError))
+			continue
+		}
+
+		err = expandMatrices(evs)
+		if err != nil {
+			errs = append(errs, pipelineError{path: file, err: err})
+			continue
+		}
+
+		// The steps are only all known once includes are resolved, so
+		// they can't be checked any earlier.
+		err = validateSteps(evs)
+		if err != nil {
+			errs = append(errs, pipelineError{path: file, err: err})
+			continue
+		}
+
+		// Pipelines are named after their path in the pipeline directory.
+		// Files with several pipelines in them act like a directory.
+		name := strings.TrimPrefix(file, d.Dir+"/")
+		name = strings.TrimSuffix(name, path.Ext(name))
+
+		if len(evs) == 1 {
+			evs[0].Name = name
+		} else {
+			for i := range evs {
+				evs[i].Name = fmt.Sprintf("%v/%v", name, evs[i].Name)
+			}
+		}
+
+		for _, ev := range evs {
+			pipelines = append(pipelines, pipeline{Event: ev, path: file})
+		}
+	}
+
+	return pipelines, errs, nil
+}
+
+// resolveIncludes resolves the includes of every pipeline in file.
+// Problems with included files are reported against those files, noting
+// which pipeline file included them.
+func resolveIncludes(inc *includer, evs []runlet.Event, file string) error {
+	for i := range evs {
+		err := inc.resolve(&evs[i], file)
+		if err == nil {
+			continue
+		}
+
+		perr := err.(pipelineError)
+		if perr.path != file {
+			perr.err = fmt.Errorf("%v (included by %v)", perr.err, file)
+		}
+
+		return perr
+	}
+
+	return nil
+}
+
+// expandMatrices expands the task matrices of every pipeline in a file.
+func expandMatrices(evs []runlet.Event) error {
+	for i, ev := range evs {
+		expanded, err := ev.ExpandMatrix()
+		if err != nil && len(evs) > 1 {
+			return fmt.Errorf("pipeline %v: %v", ev.Name, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		evs[i] = expanded
+	}
+
+	return nil
+}
+
+// validateSteps checks the step graph and conditions of every pipeline
+// in a file.
+func validateSteps(evs []runlet.Event) error {
+	for _, ev := range evs {
+		err := ev.ValidateNeeds()
+		if err == nil {
+			err = ev.ValidateWhen()
+		}
+
+		if err != nil && len(evs) > 1 {
+			return fmt.Errorf("pipeline %v: %v", ev.Name, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findPipelineFiles lists the paths of the pipeline files in dir, in
+// lexical order. Files and directories starting with an underscore are
+// left out, so fragments for includes can be kept next to pipelines.
+func findPipelineFiles(src source, d discovery, dir string) ([]string, error) {
+	entries, err := src.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	files := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "_") {
+			continue
+		}
+
+		p := path.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			if !d.Recursive {
+				continue
+			}
+
+			sub, err := findPipelineFiles(src, d, p)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, sub...)
+			continue
+		}
+
+		if contains(d.Extensions, path.Ext(entry.Name())) {
+			files = append(files, p)
+		}
+	}
+
+	return files, nil
+}
+
+// documentSeparator matches the lines that separate documents in a
+// YAML stream.
+var documentSeparator = regexp.MustCompile(`^---(\s|$)`)
+
+// document is one YAML document out of a file. line is the line of the
+// file that the document starts on. The body is padded with empty lines
+// in front so that line numbers in YAML errors match the file's.
+type document struct {
+	body []byte
+	line int
+}
+
+// splitDocuments splits a YAML stream into its documents, dropping any
+// that are empty.
+func splitDocuments(buf []byte) []document {
+	docs := []document{}
+
+	cur := document{line: 1}
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	for i, line := range lines {
+		if documentSeparator.Match(line) {
+			docs = append(docs, cur)
+
+			// Anything after the separator on the same line is part of the
+			// next document, so the separator is blanked out to keep columns
+			// lined up too.
+			body := bytes.Repeat([]byte("\n"), i)
+			cur = document{
+				body: append(append(body, "   "...), line[3:]...),
+				line: i + 1,
+			}
+
+			continue
+		}
+
+		cur.body = append(cur.body, line...)
+	}
+	docs = append(docs, cur)
+
+	nonempty := []document{}
+	for _, doc := range docs {
+		var v interface{}
+		if err := yaml.Unmarshal(doc.body, &v); err == nil && v == nil {
+			continue
+		}
+
+		nonempty = append(nonempty, doc)
+	}
+
+	return nonempty
+}
+
+// parsePipelineFile parses the pipelines in a file. JSON files are
+// parsed as YAML, which they're a subset of. YAML files can define
+// several pipelines as separate documents, in which case each one must
+// have a name.
+func parsePipelineFile(buf []byte) ([]runlet.Event, error) {
+	docs := splitDocuments(buf)
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no pipelines defined")
+	}
+
+	evs := make([]runlet.Event, len(docs))
+	names := map[string]bool{}
+	for i, doc := range docs {
+		ev, err := runlet.ParsePipeline(doc.body)
+		if err != nil {
+			return nil, err
+		}
+		evs[i] = ev
+
+		err = validatePipeline(evs[i])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", doc.line, err)
+		}
+
+		if len(docs) == 1 {
+			break
+		}
+
+		name := evs[i].Name
+		if name == "" {
+			return nil, fmt.Errorf("line %v: pipeline needs a name, since the file defines several pipelines", doc.line)
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("line %v: pipeline %v is defined more than once", doc.line, name)
+		}
+		names[name] = true
+	}
+
+	return evs, nil
+}
+
+// validatePipeline checks the parts of a pipeline that decoding it
+// doesn't.
+func validatePipeline(ev runlet.Event) error {
+	return ev.ValidateBranches()
+}
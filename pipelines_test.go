@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testStep = `
+steps:
+- name: test
+  tasks:
+  - name: test
+`
+
+// newTestTree writes files to a temporary directory and returns it as a
+// source. The returned function removes it.
+func newTestTree(t *testing.T, files map[string]string) (source, func()) {
+	dir, err := ioutil.TempDir("", "git-poller-pipelines")
+	if err != nil {
+		t.Fatalf("unable to create tree dir: %v", err)
+	}
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatalf("unable to create %v: %v", filepath.Dir(p), err)
+		}
+
+		err = ioutil.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("unable to write %v: %v", p, err)
+		}
+	}
+
+	return dirSource(dir), func() { os.RemoveAll(dir) }
+}
+
+func pipelineNames(pipelines []pipeline) []string {
+	names := []string{}
+	for _, p := range pipelines {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+func TestLoadPipelines(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/test.yaml":              testStep,
+		"pipelines/lint.yml":               testStep,
+		"pipelines/build.json":             `{"steps": [{"name": "build", "tasks": [{"name": "build"}]}]}`,
+		"pipelines/README.md":              "# Pipelines",
+		"pipelines/deploy/production.yaml": testStep,
+		"ci/check.yaml":                    testStep,
+		"ci/check.txt":                     testStep,
+	})
+	defer cleanup()
+
+	tests := []struct {
+		discovery discovery
+		names     []string
+	}{
+		{
+			names: []string{"build", "lint", "test"},
+		},
+		{
+			discovery: discovery{Recursive: true},
+			names:     []string{"build", "deploy/production", "lint", "test"},
+		},
+		{
+			discovery: discovery{Extensions: []string{".yaml"}, Recursive: true},
+			names:     []string{"deploy/production", "test"},
+		},
+		{
+			discovery: discovery{Dir: "ci/", Extensions: []string{".yaml", ".txt"}},
+			names:     []string{"check", "check"},
+		},
+	}
+
+	for _, test := range tests {
+		pipelines, errs, err := loadPipelines(src, test.discovery)
+		if err != nil {
+			t.Fatalf("expected %+v to load, got %v", test.discovery, err)
+		}
+
+		if len(errs) != 0 {
+			t.Errorf("expected %+v to load without errors, got %v", test.discovery, errs)
+		}
+
+		names := pipelineNames(pipelines)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("expected %+v to find %v, got %v", test.discovery, test.names, names)
+		}
+	}
+
+	_, _, err := loadPipelines(src, discovery{Dir: "missing"})
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a missing directory to be reported, got %v", err)
+	}
+}
+
+func TestLoadPipelinesMultipleDocuments(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/ci.yaml": `---
+name: test
+steps:
+- name: test
+  tasks:
+  - name: test
+---
+name: lint
+steps:
+- name: lint
+  tasks:
+  - name: lint
+...
+`,
+		"pipelines/unnamed.yaml": `name: one
+steps: []
+---
+steps: []
+`,
+		"pipelines/duplicate.yaml": `name: one
+steps: []
+---
+
+name: one
+steps: []
+`,
+		"pipelines/broken.yaml": `name: one
+steps: []
+---
+name: two
+steps: []
+bogus: true
+`,
+	})
+	defer cleanup()
+
+	pipelines, errs, err := loadPipelines(src, discovery{})
+	if err != nil {
+		t.Fatalf("expected pipelines to load, got %v", err)
+	}
+
+	names := pipelineNames(pipelines)
+	expected := []string{"ci/test", "ci/lint"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected pipelines %v, got %v", expected, names)
+	}
+
+	// Errors are expected in file order, with the line numbers of the
+	// whole file.
+	expectedErrs := map[string]string{
+		"pipelines/broken.yaml":    "line 6",
+		"pipelines/duplicate.yaml": "line 3: pipeline one is defined more than once",
+		"pipelines/unnamed.yaml":   "line 3: pipeline needs a name",
+	}
+
+	if len(errs) != len(expectedErrs) {
+		t.Fatalf("expected %v errors, got %v", len(expectedErrs), errs)
+	}
+
+	for _, perr := range errs {
+		if !strings.Contains(perr.err.Error(), expectedErrs[perr.path]) {
+			t.Errorf("expected error for %v to contain %q, got %v", perr.path, expectedErrs[perr.path], perr.err)
+		}
+	}
+}
+
+func TestDiscoveryValidate(t *testing.T) {
+	valid := []discovery{
+		{},
+		{Dir: ".ci/pipelines", Extensions: []string{".yaml"}},
+		{Dir: "./pipelines/"},
+	}
+
+	for _, d := range valid {
+		if err := d.validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", d, err)
+		}
+	}
+
+	invalid := []discovery{
+		{Dir: "/etc"},
+		{Dir: "../elsewhere"},
+		{Dir: "pipelines/../.."},
+		{Extensions: []string{"yaml"}},
+	}
+
+	for _, d := range invalid {
+		if err := d.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", d)
+		}
+	}
+}
+
+func TestLoadPipelinesIncludes(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/_shared/lint.yaml": `
+steps:
+- name: lint
+  tasks:
+  - name: vet
+`,
+		"pipelines/_shared/setup.yaml": `
+include:
+- pipelines/_shared/checkout.yaml
+tasks:
+- name: deps
+`,
+		"pipelines/_shared/checkout.yaml": `
+tasks:
+- name: checkout
+`,
+		"pipelines/_shared/loop-a.yaml": "include: [pipelines/_shared/loop-b.yaml]\n",
+		"pipelines/_shared/loop-b.yaml": "include: [pipelines/_shared/loop-a.yaml]\n",
+		"pipelines/_shared/broken.yaml": "steps: []\n\nbogus: true\n",
+		"pipelines/test.yaml": `
+include:
+- pipelines/_shared/lint.yaml
+steps:
+- name: test
+  include:
+  - pipelines/_shared/setup.yaml
+  tasks:
+  - name: test
+`,
+		"pipelines/loop.yaml":    "include: [pipelines/_shared/loop-a.yaml]\nsteps: []\n",
+		"pipelines/missing.yaml": "include: [pipelines/_shared/missing.yaml]\nsteps: []\n",
+		"pipelines/outside.yaml": "include: [../secrets.yaml]\nsteps: []\n",
+		"pipelines/broken.yaml":  "include: [pipelines/_shared/broken.yaml]\nsteps: []\n",
+		"pipelines/wrong.yaml":   "include: [pipelines/_shared/checkout.yaml]\nsteps: []\n",
+	})
+	defer cleanup()
+
+	pipelines, errs, err := loadPipelines(src, discovery{Recursive: true})
+	if err != nil {
+		t.Fatalf("expected pipelines to load, got %v", err)
+	}
+
+	if len(pipelines) != 1 || pipelines[0].Name != "test" {
+		t.Fatalf("expected only the test pipeline to load, got %v", pipelineNames(pipelines))
+	}
+
+	steps := []string{}
+	for _, step := range pipelines[0].Steps {
+		tasks := []string{}
+		for _, task := range step.Tasks {
+			tasks = append(tasks, task.Name)
+		}
+
+		steps = append(steps, step.Name+":"+strings.Join(tasks, ","))
+	}
+
+	expected := []string{"lint:vet", "test:checkout,deps,test"}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected steps %v, got %v", expected, steps)
+	}
+
+	expectedErrs := []pipelineError{
+		{
+			path: "pipelines/_shared/broken.yaml",
+			err:  errors.New("line 3: field bogus not found in type main.fragment (included by pipelines/broken.yaml)"),
+		},
+		{
+			path: "pipelines/_shared/loop-b.yaml",
+			err:  errors.New("include cycle: pipelines/loop.yaml -> pipelines/_shared/loop-a.yaml -> pipelines/_shared/loop-b.yaml -> pipelines/_shared/loop-a.yaml (included by pipelines/loop.yaml)"),
+		},
+		{
+			path: "pipelines/missing.yaml",
+			err:  errors.New("unable to include pipelines/_shared/missing.yaml, it doesn't exist"),
+		},
+		{
+			path: "pipelines/outside.yaml",
+			err:  errors.New("unable to include ../secrets.yaml, it's outside of the repo"),
+		},
+		{
+			path: "pipelines/_shared/checkout.yaml",
+			err:  errors.New("fragment defines tasks, which can only be included by a step (included by pipelines/wrong.yaml)"),
+		},
+	}
+
+	if len(errs) != len(expectedErrs) {
+		t.Fatalf("expected %v errors, got %v", len(expectedErrs), errs)
+	}
+
+	for i, perr := range errs {
+		if perr.path != expectedErrs[i].path || !strings.Contains(perr.err.Error(), expectedErrs[i].err.Error()) {
+			t.Errorf("expected error %v, got %v", expectedErrs[i], perr)
+		}
+	}
+}
+
+func TestLoadPipelinesNeeds(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/_shared/lint.yaml": "steps:\n- name: lint\n",
+		"pipelines/test.yaml": `
+include:
+- pipelines/_shared/lint.yaml
+steps:
+- name: test
+  needs: [lint]
+- name: deploy
+  needs: [lint, test]
+`,
+		"pipelines/cycle.yaml": `
+steps:
+- name: test
+  needs: [deploy]
+- name: deploy
+  needs: [test]
+`,
+	})
+	defer cleanup()
+
+	pipelines, errs, err := loadPipelines(src, discovery{})
+	if err != nil {
+		t.Fatalf("expected pipelines to load, got %v", err)
+	}
+
+	if len(pipelines) != 1 || pipelines[0].Name != "test" {
+		t.Fatalf("expected only the test pipeline to load, got %v", pipelineNames(pipelines))
+	}
+
+	expected := []string{"lint", "test"}
+	if needs := pipelines[0].Steps[2].Needs; !reflect.DeepEqual(needs, expected) {
+		t.Errorf("expected deploy to need %v, got %v", expected, needs)
+	}
+
+	if len(errs) != 1 || errs[0].path != "pipelines/cycle.yaml" {
+		t.Fatalf("expected an error for the cycle, got %v", errs)
+	}
+}
+
+func TestLoadPipelinesMatrix(t *testing.T) {
+	src, cleanup := newTestTree(t, map[string]string{
+		"pipelines/test.yaml": `
+steps:
+- name: test
+  tasks:
+  - name: test
+    arguments:
+      image: "golang:{{ .Branch }}"
+    matrix:
+      go: ["1.10", "1.11"]
+      os: [linux]
+`,
+		"pipelines/empty.yaml": `
+steps:
+- name: test
+  tasks:
+  - name: test
+    matrix:
+      go: []
+`,
+	})
+	defer cleanup()
+
+	pipelines, errs, err := loadPipelines(src, discovery{})
+	if err != nil {
+		t.Fatalf("expected pipelines to load, got %v", err)
+	}
+
+	if len(pipelines) != 1 || len(pipelines[0].Steps) != 1 {
+		t.Fatalf("expected the test pipeline with one step, got %+v", pipelines)
+	}
+
+	names := []string{}
+	for _, task := range pipelines[0].Steps[0].Tasks {
+		names = append(names, task.Name)
+	}
+
+	expected := []string{"test[go=1.10,os=linux]", "test[go=1.11,os=linux]"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected tasks %v, got %v", expected, names)
+	}
+
+	if len(errs) != 1 || errs[0].path != "pipelines/empty.yaml" {
+		t.Fatalf("expected an error for the empty matrix, got %v", errs)
+	}
+}
@@ -0,0 +1,76 @@
+package runlet
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic describes a problem with a pipeline file that kept it from
+// running. Diagnostics are published alongside pipeline events so that
+// whoever pushed the commit can find out what's wrong.
+type Diagnostic struct {
+	Remote string `json:"remote"`
+	Branch string `json:"branch,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Commit string `json:"commit"`
+
+	// File is the path of the pipeline file relative to the root of
+	// the repo. It's empty for problems with the ref itself, like a tag
+	// that doesn't point at a commit.
+	File string `json:"file"`
+	// Pipeline is the name of the pipeline the problem is in, if the
+	// file could be read far enough to know it.
+	Pipeline string `json:"pipeline,omitempty"`
+
+	// Line and Column are where in File the problem is, when that's
+	// known. Both start at 1.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// positionRegexp matches the positions YAML errors and our own
+// pipeline errors are prefixed with, once "yaml: " is trimmed. It's
+// anchored so that "line" followed by a number anywhere else in a
+// message isn't taken for a position. Lists of strict decoding errors
+// start with the position of the first.
+var positionRegexp = regexp.MustCompile(`^(?:unmarshal errors:\n  )?line (\d+)(?:, column (\d+))?(: )?`)
+
+// NewDiagnostic builds a Diagnostic for an error in the given file,
+// picking the line and column out of the error message if it has them.
+// When an error lists several problems, the position of the first is
+// used.
+func NewDiagnostic(file string, err error) Diagnostic {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+
+	// Strict decoding lists every problem on its own line, which only
+	// needs the list when there's more than one.
+	if strings.HasPrefix(msg, "unmarshal errors:\n  ") && strings.Count(msg, "\n") == 1 {
+		msg = strings.TrimPrefix(msg, "unmarshal errors:\n  ")
+	}
+
+	d := Diagnostic{
+		File:    file,
+		Message: msg,
+	}
+
+	m := positionRegexp.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return d
+	}
+
+	d.Line, _ = strconv.Atoi(msg[m[2]:m[3]])
+	if m[4] >= 0 {
+		d.Column, _ = strconv.Atoi(msg[m[4]:m[5]])
+	}
+
+	// Once the position is picked out, it doesn't need repeating at the
+	// start of the message.
+	if strings.HasPrefix(msg, "line ") && m[6] >= 0 {
+		d.Message = msg[m[1]:]
+	}
+
+	return d
+}
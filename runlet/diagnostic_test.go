@@ -0,0 +1,76 @@
+package runlet
+
+import (
+	"errors"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestNewDiagnostic(t *testing.T) {
+	var ev Event
+	strictErr := yaml.UnmarshalStrict([]byte("name: test\n\nbogus: true\n"), &ev)
+	syntaxErr := yaml.Unmarshal([]byte("name: test\nsteps: [\n"), &ev)
+
+	tests := []struct {
+		err     error
+		line    int
+		column  int
+		message string
+	}{
+		{
+			err:     strictErr,
+			line:    3,
+			message: "field bogus not found in type runlet.Event",
+		},
+		{
+			err:     syntaxErr,
+			line:    2,
+			message: "did not find expected node content",
+		},
+		{
+			err:     errors.New("line 7, column 3: something's off"),
+			line:    7,
+			column:  3,
+			message: "something's off",
+		},
+		{
+			err:     errors.New("yaml: unmarshal errors:\n  line 2: one\n  line 5: two"),
+			line:    2,
+			message: "unmarshal errors:\n  line 2: one\n  line 5: two",
+		},
+		{
+			err:     errors.New("no pipelines defined"),
+			message: "no pipelines defined",
+		},
+		{
+			err:     errors.New("step deadline 5 is broken"),
+			message: "step deadline 5 is broken",
+		},
+		{
+			err:     errors.New("pipeline test: needs line 4: nope"),
+			message: "pipeline test: needs line 4: nope",
+		},
+		{
+			err:     errors.New("line 4: yaml: line 2: did not find expected key"),
+			line:    4,
+			message: "yaml: line 2: did not find expected key",
+		},
+	}
+
+	for _, test := range tests {
+		d := NewDiagnostic("pipelines/test.yaml", test.err)
+
+		if d.File != "pipelines/test.yaml" {
+			t.Errorf("expected file to be kept, got %v", d.File)
+		}
+
+		if d.Line != test.line || d.Column != test.column {
+			t.Errorf("expected %q to be at %v:%v, got %v:%v", test.err, test.line, test.column, d.Line, d.Column)
+		}
+
+		if test.message != "" && d.Message != test.message {
+			t.Errorf("expected message %q, got %q", test.message, d.Message)
+		}
+	}
+}
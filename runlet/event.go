@@ -1,6 +1,11 @@
 package runlet
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/run-ci/git-poller/glob"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,12 +19,109 @@ func init() {
 // be run together as a pipeline. This is the format
 // the runlet is expecting events in.
 type Event struct {
-	Name string `json:"name"`
-	// Branch in the top level event corresponds to the branch
-	// the pipeline is specifying to run under.
-	Branch string `yaml:"branch" json:"-"`
+	Name string `yaml:"name" json:"name"`
+	// Version is the version of the pipeline format the pipeline was
+	// written in. Pipelines are always upgraded to CurrentVersion when
+	// they're parsed. See ParsePipeline.
+	Version int `yaml:"version" json:"-"`
+	// Branches are globs of branch names the pipeline runs for.
+	// Patterns starting with "!" exclude branches instead. See
+	// MatchesBranch.
+	Branches []string `yaml:"branches" json:"-"`
+
 	Remote Remote `json:"git_remote"`
 	Steps  []Step `yaml:"steps" json:"steps"`
+
+	// Tags are globs of tag names the pipeline runs for when tags are
+	// pushed. Pipelines without Tags never run for tags.
+	Tags []string `yaml:"tags" json:"-"`
+
+	// Paths and IgnorePaths are globs that limit the pipeline to
+	// changes touching certain files. See MatchesPaths.
+	Paths       []string `yaml:"paths" json:"-"`
+	IgnorePaths []string `yaml:"ignore_paths" json:"-"`
+
+	// Include are files in the repo whose steps are added in front of
+	// the pipeline's own. They're resolved before the event is sent.
+	Include []string `yaml:"include" json:"-"`
+
+	// When is a condition from the expr package that has to hold for
+	// the pipeline to run. See ApplyWhen.
+	When string `yaml:"when" json:"-"`
+}
+
+// MatchesBranch reports whether the pipeline should run when the given
+// branch changes. A branch matches if it matches at least one of the
+// listed patterns, or only exclusions are listed, and doesn't match any
+// exclusion. Pipelines that list no branches run on every branch,
+// unless they list tags, in which case they only run for tags.
+func (ev Event) MatchesBranch(branch string) bool {
+	if len(ev.Branches) == 0 {
+		return len(ev.Tags) == 0
+	}
+
+	included := false
+	onlyExcludes := true
+	for _, pattern := range ev.Branches {
+		if strings.HasPrefix(pattern, "!") {
+			if glob.Match(pattern[1:], branch) {
+				return false
+			}
+
+			continue
+		}
+
+		onlyExcludes = false
+		if glob.Match(pattern, branch) {
+			included = true
+		}
+	}
+
+	return included || onlyExcludes
+}
+
+// ValidateBranches returns an error if any of the pipeline's branch
+// patterns are malformed.
+func (ev Event) ValidateBranches() error {
+	for _, pattern := range ev.Branches {
+		err := glob.Validate(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return fmt.Errorf("invalid branch pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// MatchesTag reports whether the pipeline should run when the given
+// tag is pushed.
+func (ev Event) MatchesTag(tag string) bool {
+	return glob.MatchAny(ev.Tags, tag)
+}
+
+// MatchesPaths reports whether a change to the given paths should
+// trigger the pipeline. A path counts if it matches one of Paths, or
+// Paths is empty, and doesn't match any of IgnorePaths. The pipeline
+// is triggered if at least one changed path counts. If changed is nil,
+// the changes aren't known and the pipeline is always triggered.
+func (ev Event) MatchesPaths(changed []string) bool {
+	if changed == nil || (len(ev.Paths) == 0 && len(ev.IgnorePaths) == 0) {
+		return true
+	}
+
+	for _, path := range changed {
+		if len(ev.Paths) > 0 && !glob.MatchAny(ev.Paths, path) {
+			continue
+		}
+
+		if glob.MatchAny(ev.IgnorePaths, path) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
 }
 
 // Step is a logical grouping of tasks that can be run
@@ -27,6 +129,19 @@ type Event struct {
 type Step struct {
 	Name  string `yaml:"name" json:"name"`
 	Tasks []Task `yaml:"tasks" json:"tasks"`
+
+	// Needs are the names of the steps that have to finish before this
+	// one starts. Steps that don't need each other can run in parallel.
+	// See ValidateNeeds.
+	Needs []string `yaml:"needs" json:"needs,omitempty"`
+
+	// Include are files in the repo whose tasks are added in front of
+	// the step's own. They're resolved before the event is sent.
+	Include []string `yaml:"include" json:"-"`
+
+	// When is a condition from the expr package that has to hold for
+	// the step to run.
+	When string `yaml:"when" json:"-"`
 }
 
 // Task is a run task, but its arguments are actual
@@ -34,10 +149,45 @@ type Step struct {
 type Task struct {
 	Name      string                 `yaml:"name" json:"name"`
 	Arguments map[string]interface{} `yaml:"arguments" json:"arguments"`
+
+	// Matrix maps argument names to the values the task should be run
+	// with. The task is expanded into one task for every combination of
+	// values before the event is sent. See ExpandMatrix.
+	Matrix map[string][]interface{} `yaml:"matrix" json:"-"`
 }
 
 // Remote is a reference to some remote repository.
 type Remote struct {
 	URL    string `json:"url"`
 	Branch string `json:"branch"`
+	// Tag is set instead of Branch when the event was triggered by a
+	// tag. TagMessage is only set for annotated tags.
+	Tag        string `json:"tag,omitempty"`
+	TagMessage string `json:"tag_message,omitempty"`
+	// Commit is the commit the pipeline should run against. Runlets
+	// should check out exactly this commit rather than the branch, which
+	// may have moved on since.
+	Commit Commit `json:"commit"`
+	// PreviousHead is the SHA the branch pointed to before this event,
+	// if it was known.
+	PreviousHead string `json:"previous_head,omitempty"`
+	// Commits are all the commits this event was triggered for, oldest
+	// first.
+	Commits []Commit `json:"commits"`
+}
+
+// Commit is the metadata of a git commit.
+type Commit struct {
+	SHA       string    `json:"sha"`
+	Parents   []string  `json:"parents"`
+	Author    Signature `json:"author"`
+	Committer Signature `json:"committer"`
+	Message   string    `json:"message"`
+}
+
+// Signature is who made a commit and when.
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Time  time.Time `json:"time"`
 }
@@ -0,0 +1,107 @@
+package runlet
+
+import "testing"
+
+func TestMatchesPaths(t *testing.T) {
+	ev := Event{
+		Paths:       []string{"src/**", "go.mod"},
+		IgnorePaths: []string{"**/*.md"},
+	}
+
+	tests := []struct {
+		changed  []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{}, false},
+		{[]string{"go.mod"}, true},
+		{[]string{"src/main.go"}, true},
+		{[]string{"src/README.md"}, false},
+		{[]string{"docs/index.md", "src/README.md"}, false},
+		{[]string{"docs/index.md", "src/a/b.go"}, true},
+	}
+
+	for _, test := range tests {
+		if actual := ev.MatchesPaths(test.changed); actual != test.expected {
+			t.Errorf("expected MatchesPaths(%v) to be %v, got %v", test.changed, test.expected, actual)
+		}
+	}
+
+	if !(Event{}).MatchesPaths([]string{"anything"}) {
+		t.Error("expected pipeline without path filters to match any change")
+	}
+
+	ignoreOnly := Event{IgnorePaths: []string{"docs/**"}}
+	if ignoreOnly.MatchesPaths([]string{"docs/a.md"}) {
+		t.Error("expected docs-only change to be ignored")
+	}
+	if !ignoreOnly.MatchesPaths([]string{"docs/a.md", "main.go"}) {
+		t.Error("expected change outside of docs to match")
+	}
+}
+
+func TestMatchesBranch(t *testing.T) {
+	tests := []struct {
+		ev       Event
+		matches  []string
+		excludes []string
+	}{
+		{
+			// Pipelines that don't say run on every branch.
+			ev:      Event{},
+			matches: []string{"master", "feature/x"},
+		},
+		{
+			ev:       Event{Tags: []string{"v*"}},
+			excludes: []string{"master"},
+		},
+		{
+			ev:       Event{Branches: []string{"master"}},
+			matches:  []string{"master"},
+			excludes: []string{"main", "feature/master"},
+		},
+		{
+			ev:       Event{Branches: []string{"main", "release/*"}},
+			matches:  []string{"main", "release/1.0"},
+			excludes: []string{"master", "release/1.0/hotfix"},
+		},
+		{
+			ev:       Event{Branches: []string{"release/**", "!release/old/**"}},
+			matches:  []string{"release/1.0", "release/1.0/hotfix"},
+			excludes: []string{"release/old/0.1", "master"},
+		},
+		{
+			ev:       Event{Branches: []string{"!wip/*"}},
+			matches:  []string{"master", "feature/x"},
+			excludes: []string{"wip/x"},
+		},
+	}
+
+	for _, test := range tests {
+		for _, branch := range test.matches {
+			if !test.ev.MatchesBranch(branch) {
+				t.Errorf("expected %+v to match %v", test.ev, branch)
+			}
+		}
+
+		for _, branch := range test.excludes {
+			if test.ev.MatchesBranch(branch) {
+				t.Errorf("expected %+v not to match %v", test.ev, branch)
+			}
+		}
+	}
+}
+
+func TestValidateBranches(t *testing.T) {
+	if err := (Event{Branches: []string{"main", "!release/*"}}).ValidateBranches(); err != nil {
+		t.Errorf("expected valid patterns, got %v", err)
+	}
+
+	if err := (Event{Branches: []string{"!release/["}}).ValidateBranches(); err == nil {
+		t.Error("expected a malformed exclusion to be invalid")
+	}
+
+	if err := (Event{Branches: []string{"["}}).ValidateBranches(); err == nil {
+		t.Error("expected a malformed branch to be invalid")
+	}
+}
@@ -0,0 +1,97 @@
+package runlet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxMatrixTasks is the most tasks a single matrix can expand into, so
+// a typo can't flood the runlet.
+const maxMatrixTasks = 256
+
+// ExpandMatrix returns a copy of the event with every task that has a
+// matrix replaced by one task per combination of its values, in the
+// same step. The values are added to each task's arguments, and the
+// tasks are named after them, like "test[go=1.11,os=linux]". Keys are
+// ordered by name, and the last key changes fastest.
+func (ev Event) ExpandMatrix() (Event, error) {
+	steps := make([]Step, len(ev.Steps))
+	for i, step := range ev.Steps {
+		steps[i] = step
+		steps[i].Tasks = []Task{}
+
+		for _, task := range step.Tasks {
+			if len(task.Matrix) == 0 {
+				steps[i].Tasks = append(steps[i].Tasks, task)
+				continue
+			}
+
+			tasks, err := expandTask(task)
+			if err != nil {
+				return ev, fmt.Errorf("step %v, task %v: %v", step.Name, task.Name, err)
+			}
+
+			steps[i].Tasks = append(steps[i].Tasks, tasks...)
+		}
+	}
+
+	ev.Steps = steps
+	return ev, nil
+}
+
+func expandTask(task Task) ([]Task, error) {
+	keys := make([]string, 0, len(task.Matrix))
+	combinations := 1
+	for key, values := range task.Matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix %v has no values", key)
+		}
+
+		if _, ok := task.Arguments[key]; ok {
+			return nil, fmt.Errorf("%v is set in both arguments and matrix", key)
+		}
+
+		for _, v := range values {
+			switch v.(type) {
+			case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+				return nil, fmt.Errorf("matrix %v has a value that isn't a scalar", key)
+			}
+		}
+
+		keys = append(keys, key)
+		combinations *= len(values)
+		if combinations > maxMatrixTasks {
+			return nil, fmt.Errorf("matrix expands into more than %v tasks", maxMatrixTasks)
+		}
+	}
+	sort.Strings(keys)
+
+	tasks := make([]Task, 0, combinations)
+	for n := 0; n < combinations; n++ {
+		args := make(map[string]interface{}, len(task.Arguments)+len(keys))
+		for k, v := range task.Arguments {
+			args[k] = v
+		}
+
+		// n is read as a number whose digits are the indexes of each
+		// key's values, with the last key as the lowest digit.
+		labels := make([]string, len(keys))
+		rest := n
+		for i := len(keys) - 1; i >= 0; i-- {
+			values := task.Matrix[keys[i]]
+			v := values[rest%len(values)]
+			rest /= len(values)
+
+			args[keys[i]] = v
+			labels[i] = fmt.Sprintf("%v=%v", keys[i], v)
+		}
+
+		tasks = append(tasks, Task{
+			Name:      fmt.Sprintf("%v[%v]", task.Name, strings.Join(labels, ",")),
+			Arguments: args,
+		})
+	}
+
+	return tasks, nil
+}
@@ -0,0 +1,100 @@
+package runlet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	ev := Event{
+		Steps: []Step{
+			{
+				Name: "test",
+				Tasks: []Task{
+					{Name: "lint"},
+					{
+						Name:      "test",
+						Arguments: map[string]interface{}{"race": true},
+						Matrix: map[string][]interface{}{
+							"os": {"linux", "darwin"},
+							"go": {"1.10", 1.11},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	expanded, err := ev.ExpandMatrix()
+	if err != nil {
+		t.Fatalf("expected the matrix to expand, got %v", err)
+	}
+
+	expected := []Task{
+		{Name: "lint"},
+		{
+			Name:      "test[go=1.10,os=linux]",
+			Arguments: map[string]interface{}{"race": true, "go": "1.10", "os": "linux"},
+		},
+		{
+			Name:      "test[go=1.10,os=darwin]",
+			Arguments: map[string]interface{}{"race": true, "go": "1.10", "os": "darwin"},
+		},
+		{
+			Name:      "test[go=1.11,os=linux]",
+			Arguments: map[string]interface{}{"race": true, "go": 1.11, "os": "linux"},
+		},
+		{
+			Name:      "test[go=1.11,os=darwin]",
+			Arguments: map[string]interface{}{"race": true, "go": 1.11, "os": "darwin"},
+		},
+	}
+
+	if len(expanded.Steps) != 1 || !reflect.DeepEqual(expanded.Steps[0].Tasks, expected) {
+		t.Fatalf("expected tasks %+v, got %+v", expected, expanded.Steps)
+	}
+
+	if len(ev.Steps[0].Tasks) != 2 {
+		t.Fatal("expected the original event to be left alone")
+	}
+}
+
+func TestExpandMatrixErrors(t *testing.T) {
+	big := make([]interface{}, 20)
+
+	tests := []struct {
+		task Task
+		err  string
+	}{
+		{
+			task: Task{Matrix: map[string][]interface{}{"go": {}}},
+			err:  "matrix go has no values",
+		},
+		{
+			task: Task{
+				Arguments: map[string]interface{}{"go": "1.11"},
+				Matrix:    map[string][]interface{}{"go": {"1.10"}},
+			},
+			err: "go is set in both arguments and matrix",
+		},
+		{
+			task: Task{Matrix: map[string][]interface{}{"go": {[]interface{}{"1.10"}}}},
+			err:  "isn't a scalar",
+		},
+		{
+			task: Task{Matrix: map[string][]interface{}{"a": big, "b": big}},
+			err:  "more than 256 tasks",
+		},
+	}
+
+	for _, test := range tests {
+		test.task.Name = "test"
+		ev := Event{Steps: []Step{{Name: "test", Tasks: []Task{test.task}}}}
+
+		_, err := ev.ExpandMatrix()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected %+v to fail with %q, got %v", test.task, test.err, err)
+		}
+	}
+}
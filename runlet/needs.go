@@ -0,0 +1,100 @@
+package runlet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateNeeds returns an error if the steps' needs don't make a
+// graph the runlet can run: every step that's needed has to exist, and
+// no step can end up needing itself. Once any step has needs, every
+// step needs a unique name so it can be told apart.
+func (ev Event) ValidateNeeds() error {
+	uses := false
+	for _, step := range ev.Steps {
+		if len(step.Needs) > 0 {
+			uses = true
+			break
+		}
+	}
+
+	if !uses {
+		return nil
+	}
+
+	steps := map[string]Step{}
+	for i, step := range ev.Steps {
+		if step.Name == "" {
+			return fmt.Errorf("step %v needs a name, since steps have needs", i+1)
+		}
+
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("step %v is defined more than once", step.Name)
+		}
+
+		steps[step.Name] = step
+	}
+
+	for _, step := range ev.Steps {
+		for _, need := range step.Needs {
+			if need == step.Name {
+				return fmt.Errorf("step %v needs itself", step.Name)
+			}
+
+			if _, ok := steps[need]; !ok {
+				return fmt.Errorf("step %v needs %v, which doesn't exist", step.Name, need)
+			}
+		}
+	}
+
+	// Steps are walked depth first, so finding a step that's already on
+	// the path means the path loops back on itself.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("steps need each other in a cycle: %v", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, need := range steps[name].Needs {
+			err := visit(need)
+			if err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+		path = path[:len(path)-1]
+
+		return nil
+	}
+
+	for _, step := range ev.Steps {
+		err := visit(step.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package runlet
+
+import (
+	"strings"
+	"testing"
+)
+
+func testSteps(needs ...[]string) []Step {
+	steps := make([]Step, len(needs))
+	for i, n := range needs {
+		steps[i] = Step{Name: string('a' + rune(i)), Needs: n}
+	}
+
+	return steps
+}
+
+func TestValidateNeeds(t *testing.T) {
+	tests := []struct {
+		steps []Step
+		err   string
+	}{
+		{
+			steps: []Step{{Name: "a"}, {Name: "a"}, {}},
+		},
+		{
+			steps: testSteps(nil, []string{"a"}, []string{"a"}, []string{"b", "c"}),
+		},
+		{
+			steps: testSteps(nil, []string{"z"}),
+			err:   "step b needs z, which doesn't exist",
+		},
+		{
+			steps: testSteps([]string{"a"}),
+			err:   "step a needs itself",
+		},
+		{
+			steps: testSteps([]string{"c"}, nil, []string{"b", "d"}, []string{"a"}),
+			err:   "cycle: a -> c -> d -> a",
+		},
+		{
+			steps: []Step{{Name: "a"}, {Name: "a", Needs: []string{"b"}}, {Name: "b"}},
+			err:   "step a is defined more than once",
+		},
+		{
+			steps: []Step{{Name: "a"}, {Needs: []string{"a"}}},
+			err:   "step 2 needs a name",
+		},
+	}
+
+	for _, test := range tests {
+		err := Event{Steps: test.steps}.ValidateNeeds()
+
+		if test.err == "" && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", test.steps, err)
+		}
+
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected %+v to fail with %q, got %v", test.steps, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package runlet
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaTypes are the structs each supported version of the pipeline
+// format is decoded into, so that the schema accepts every version
+// ParsePipeline does.
+var schemaTypes = map[int]reflect.Type{
+	1: reflect.TypeOf(eventV1{}),
+	2: reflect.TypeOf(Event{}),
+}
+
+// Schema returns a JSON Schema of the pipeline file format, for editors
+// to check pipelines with as they're written. It's built from the YAML
+// tags of the struct each version is decoded into, so only the fields
+// pipelines set are in it. A pipeline has to match exactly one version,
+// which is told apart by its version key. Version 1 pipelines can leave
+// it out.
+func Schema() map[string]interface{} {
+	versions := []interface{}{}
+	for version := 1; version <= CurrentVersion; version++ {
+		schema := schemaOf(schemaTypes[version])
+
+		props := schema["properties"].(map[string]interface{})
+		props["version"] = map[string]interface{}{
+			"type": "integer",
+			"enum": []interface{}{version},
+		}
+
+		if version > 1 {
+			schema["required"] = []interface{}{"version"}
+		}
+
+		versions = append(versions, schema)
+	}
+
+	return map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "run-ci pipeline",
+		"oneOf":   versions,
+	}
+}
+
+func schemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem()),
+		}
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			tag := field.Tag.Get("yaml")
+			if tag == "" || tag == "-" {
+				continue
+			}
+
+			opts := strings.Split(tag, ",")
+			if len(opts) > 1 && opts[1] == "inline" {
+				// Inlined structs add their fields to this one.
+				inlined := schemaOf(field.Type)["properties"].(map[string]interface{})
+				for name, prop := range inlined {
+					props[name] = prop
+				}
+
+				continue
+			}
+
+			props[opts[0]] = schemaOf(field.Type)
+		}
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	default:
+		// Interfaces can hold anything.
+		return map[string]interface{}{}
+	}
+}
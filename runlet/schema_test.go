@@ -0,0 +1,222 @@
+package runlet
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+
+	// The schema has to survive being sent to an editor.
+	_, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("expected the schema to marshal, got %v", err)
+	}
+
+	versions := schema["oneOf"].([]interface{})
+	if len(versions) != CurrentVersion {
+		t.Fatalf("expected a schema for every version, got %v", len(versions))
+	}
+
+	current := versions[CurrentVersion-1].(map[string]interface{})
+	props := current["properties"].(map[string]interface{})
+
+	if _, ok := props["git_remote"]; ok {
+		t.Error("expected fields the poller fills in to be left out")
+	}
+
+	if _, ok := props["Remote"]; ok {
+		t.Error("expected fields without YAML tags to be left out")
+	}
+
+	if _, ok := props["branch"]; ok {
+		t.Error("expected the current version not to have branch")
+	}
+
+	expected := map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"type": "string"},
+	}
+	if !reflect.DeepEqual(props["branches"], expected) {
+		t.Errorf("expected branches to be %v, got %v", expected, props["branches"])
+	}
+
+	steps := props["steps"].(map[string]interface{})["items"].(map[string]interface{})
+	tasks := steps["properties"].(map[string]interface{})["tasks"].(map[string]interface{})["items"].(map[string]interface{})
+	args := tasks["properties"].(map[string]interface{})["arguments"]
+
+	expected = map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected arguments to be %v, got %v", expected, args)
+	}
+
+	if steps["additionalProperties"] != false {
+		t.Error("expected steps not to allow unknown keys")
+	}
+
+	v1 := versions[0].(map[string]interface{})["properties"].(map[string]interface{})
+	for _, name := range []string{"branch", "branches", "steps"} {
+		if _, ok := v1[name]; !ok {
+			t.Errorf("expected version 1 to have %v", name)
+		}
+	}
+}
+
+func TestSchemaValidates(t *testing.T) {
+	// The schema goes through JSON like it would on its way to an editor.
+	buf, err := json.Marshal(Schema())
+	if err != nil {
+		t.Fatalf("got error marshaling schema: %v", err)
+	}
+
+	var schema interface{}
+	err = json.Unmarshal(buf, &schema)
+	if err != nil {
+		t.Fatalf("got error unmarshaling schema: %v", err)
+	}
+
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{"branch: master\nsteps:\n- name: test\n", true},
+		{"version: 1\nbranch: master\nbranches: [develop]\nsteps: []\n", true},
+		{"version: 2\nbranches: [master]\nsteps:\n- name: test\n  tasks:\n  - name: unit\n    arguments:\n      race: true\n", true},
+		{"version: 2\nbranch: master\nsteps: []\n", false},
+		{"branches: [master]\nbogus: true\n", false},
+		{"version: 3\nsteps: []\n", false},
+	}
+
+	for _, test := range tests {
+		var doc interface{}
+		err := yaml.Unmarshal([]byte(test.doc), &doc)
+		if err != nil {
+			t.Fatalf("got error unmarshaling %q: %v", test.doc, err)
+		}
+
+		err = validate(schema, jsonValue(doc))
+		if test.valid && err != nil {
+			t.Errorf("expected %q to be valid, got %v", test.doc, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %q to be invalid", test.doc)
+		}
+	}
+}
+
+// jsonValue converts a document decoded from YAML to what it'd be if it
+// had been decoded from JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+		return v
+	case int:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+// validate checks v against the parts of JSON Schema that Schema uses.
+func validate(schema, v interface{}) error {
+	s := schema.(map[string]interface{})
+
+	if versions, ok := s["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, version := range versions {
+			if validate(version, v) == nil {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			return fmt.Errorf("matched %v schemas instead of one", matched)
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+
+		if !found {
+			return fmt.Errorf("%v isn't one of %v", v, enum)
+		}
+	}
+
+	switch s["type"] {
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%v isn't a string", v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%v isn't a boolean", v)
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%v isn't a number", v)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v isn't an array", v)
+		}
+
+		for _, item := range items {
+			err := validate(s["items"], item)
+			if err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v isn't an object", v)
+		}
+
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%v is required", name)
+			}
+		}
+
+		props, _ := s["properties"].(map[string]interface{})
+		for name, val := range obj {
+			prop, ok := props[name]
+			if !ok {
+				prop = s["additionalProperties"]
+			}
+
+			if prop == false {
+				return fmt.Errorf("%v isn't allowed", name)
+			}
+
+			err := validate(prop, val)
+			if err != nil {
+				return fmt.Errorf("%v: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
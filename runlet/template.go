@@ -0,0 +1,129 @@
+package runlet
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is what templates in task arguments are expanded with.
+type TemplateData struct {
+	Commit   Commit
+	Branch   string
+	Tag      string
+	Remote   Remote
+	Pipeline struct {
+		Name string
+	}
+}
+
+// ExpandArguments returns a copy of the event with the templates in its
+// task arguments expanded, so "{{ .Commit.SHA }}" becomes the SHA of
+// the commit the event is for. Templates can be anywhere in argument
+// values, including inside lists and maps. The event's Remote must
+// already be filled in.
+func (ev Event) ExpandArguments() (Event, error) {
+	data := TemplateData{
+		Commit: ev.Remote.Commit,
+		Branch: ev.Remote.Branch,
+		Tag:    ev.Remote.Tag,
+		Remote: ev.Remote,
+	}
+	data.Pipeline.Name = ev.Name
+
+	steps := make([]Step, len(ev.Steps))
+	for i, step := range ev.Steps {
+		steps[i] = step
+		steps[i].Tasks = make([]Task, len(step.Tasks))
+
+		for j, task := range step.Tasks {
+			steps[i].Tasks[j] = task
+
+			if task.Arguments == nil {
+				continue
+			}
+
+			args := make(map[string]interface{}, len(task.Arguments))
+			for key, val := range task.Arguments {
+				expanded, err := expandValue(val, data)
+				if err != nil {
+					return ev, fmt.Errorf("step %v, task %v, argument %v: %v", step.Name, task.Name, key, err)
+				}
+
+				args[key] = expanded
+			}
+
+			steps[i].Tasks[j].Arguments = args
+		}
+	}
+
+	ev.Steps = steps
+	return ev, nil
+}
+
+// expandValue expands the templates in an argument value, walking into
+// lists and maps. Anything that isn't a string is left alone.
+func expandValue(val interface{}, data TemplateData) (interface{}, error) {
+	switch val := val.(type) {
+	case string:
+		return expandString(val, data)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, v := range val {
+			expanded, err := expandValue(v, data)
+			if err != nil {
+				return nil, err
+			}
+
+			out[i] = expanded
+		}
+
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			expanded, err := expandValue(v, data)
+			if err != nil {
+				return nil, err
+			}
+
+			out[k] = expanded
+		}
+
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(val))
+		for k, v := range val {
+			expanded, err := expandValue(v, data)
+			if err != nil {
+				return nil, err
+			}
+
+			out[k] = expanded
+		}
+
+		return out, nil
+	default:
+		return val, nil
+	}
+}
+
+func expandString(s string, data TemplateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	tmpl, err := template.New("argument").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,101 @@
+package runlet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandArguments(t *testing.T) {
+	ev := Event{
+		Name: "deploy",
+		Remote: Remote{
+			URL:    "https://example.com/repo.git",
+			Branch: "master",
+			Commit: Commit{
+				SHA:    "abc123",
+				Author: Signature{Name: "Test Author"},
+			},
+		},
+		Steps: []Step{
+			{
+				Name: "build",
+				Tasks: []Task{
+					{
+						Name: "image",
+						Arguments: map[string]interface{}{
+							"image":    "repo:{{ .Commit.SHA }}",
+							"pipeline": "{{ .Pipeline.Name }} on {{ .Branch }}{{ .Tag }}",
+							"retries":  3,
+							"tags":     []interface{}{"{{ .Branch }}", "latest"},
+							"labels": map[interface{}]interface{}{
+								"author": "{{ .Commit.Author.Name }}",
+								"remote": "{{ .Remote.URL }}",
+							},
+						},
+					},
+					{
+						Name: "noargs",
+					},
+				},
+			},
+		},
+	}
+
+	expanded, err := ev.ExpandArguments()
+	if err != nil {
+		t.Fatalf("expected arguments to expand, got %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"image":    "repo:abc123",
+		"pipeline": "deploy on master",
+		"retries":  3,
+		"tags":     []interface{}{"master", "latest"},
+		"labels": map[interface{}]interface{}{
+			"author": "Test Author",
+			"remote": "https://example.com/repo.git",
+		},
+	}
+
+	args := expanded.Steps[0].Tasks[0].Arguments
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected arguments %v, got %v", expected, args)
+	}
+
+	if ev.Steps[0].Tasks[0].Arguments["image"] != "repo:{{ .Commit.SHA }}" {
+		t.Fatal("expected the original event to be left alone")
+	}
+
+	if expanded.Steps[0].Tasks[1].Arguments != nil {
+		t.Fatalf("expected tasks without arguments to stay that way, got %v", expanded.Steps[0].Tasks[1].Arguments)
+	}
+}
+
+func TestExpandArgumentsErrors(t *testing.T) {
+	bad := []string{
+		"{{ .Commit.Nope }}",
+		"{{ .Pipeline.Name",
+		"{{ template \"missing\" }}",
+	}
+
+	for _, arg := range bad {
+		ev := Event{
+			Steps: []Step{
+				{
+					Name: "build",
+					Tasks: []Task{
+						{
+							Name:      "image",
+							Arguments: map[string]interface{}{"image": arg},
+						},
+					},
+				},
+			},
+		}
+
+		_, err := ev.ExpandArguments()
+		if err == nil {
+			t.Errorf("expected %q to fail to expand", arg)
+		}
+	}
+}
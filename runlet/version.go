@@ -0,0 +1,113 @@
+package runlet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the version of the pipeline format Event is in.
+//
+// Version 1 is what pipelines without a version are in. It has branch
+// for a single branch, as well as branches.
+//
+// Version 2 drops branch in favor of branches.
+const CurrentVersion = 2
+
+// decoders decode each supported version of the pipeline format and
+// upgrade it to the current one. Every version is decoded strictly into
+// a struct of its own, so unknown keys are still caught with the line
+// they're on.
+var decoders = map[int]func([]byte) (Event, error){
+	1: decodeV1,
+	2: decodeV2,
+}
+
+// versionLine matches the line that sets the version in a pipeline, to
+// point diagnostics about the version at it.
+var versionLine = regexp.MustCompile(`(?m)^version\s*:`)
+
+// ParsePipeline parses a pipeline document in any supported version of
+// the pipeline format and upgrades it to CurrentVersion.
+func ParsePipeline(buf []byte) (Event, error) {
+	var header struct {
+		Version int `yaml:"version"`
+	}
+
+	err := yaml.Unmarshal(buf, &header)
+	if err != nil {
+		return Event{}, err
+	}
+
+	version := header.Version
+	if version == 0 {
+		version = 1
+	}
+
+	decode, ok := decoders[version]
+	if !ok {
+		msg := fmt.Sprintf("pipeline version %v isn't supported, the newest supported version is %v", version, CurrentVersion)
+		if version < 1 {
+			msg = fmt.Sprintf("pipeline version %v is invalid, versions start at 1", version)
+		}
+
+		if loc := versionLine.FindIndex(buf); loc != nil {
+			line := bytes.Count(buf[:loc[0]], []byte("\n")) + 1
+			return Event{}, fmt.Errorf("line %v: %v", line, msg)
+		}
+
+		return Event{}, errors.New(msg)
+	}
+
+	ev, err := decode(buf)
+	if err != nil {
+		return Event{}, err
+	}
+
+	ev.Version = CurrentVersion
+	return ev, nil
+}
+
+// eventV1 is a pipeline in version 1 of the format.
+type eventV1 struct {
+	Branch string `yaml:"branch"`
+
+	Event `yaml:",inline"`
+}
+
+func decodeV1(buf []byte) (Event, error) {
+	var v1 eventV1
+	err := yaml.UnmarshalStrict(buf, &v1)
+	if err != nil {
+		// The versioned struct is an implementation detail, so errors
+		// mention the type pipelines end up as instead.
+		return Event{}, errors.New(strings.Replace(err.Error(), "runlet.eventV1", "runlet.Event", -1))
+	}
+
+	return migrateV1(v1), nil
+}
+
+// migrateV1 upgrades a version 1 pipeline to version 2, where its
+// branch is just one of its branches.
+func migrateV1(v1 eventV1) Event {
+	ev := v1.Event
+	if v1.Branch != "" {
+		ev.Branches = append([]string{v1.Branch}, ev.Branches...)
+	}
+
+	return ev
+}
+
+func decodeV2(buf []byte) (Event, error) {
+	var ev Event
+	err := yaml.UnmarshalStrict(buf, &ev)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return ev, nil
+}
@@ -0,0 +1,79 @@
+package runlet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	tests := []struct {
+		src      string
+		branches []string
+	}{
+		{
+			// Pipelines without a version are version 1.
+			src:      "branch: master\nbranches: [develop]\nsteps: []\n",
+			branches: []string{"master", "develop"},
+		},
+		{
+			src:      "version: 1\nbranch: master\nsteps: []\n",
+			branches: []string{"master"},
+		},
+		{
+			src:      "version: 2\nbranches: [master, release/*]\nsteps: []\n",
+			branches: []string{"master", "release/*"},
+		},
+	}
+
+	for _, test := range tests {
+		ev, err := ParsePipeline([]byte(test.src))
+		if err != nil {
+			t.Errorf("expected %q to parse, got %v", test.src, err)
+			continue
+		}
+
+		if ev.Version != CurrentVersion {
+			t.Errorf("expected %q to be upgraded to version %v, got %v", test.src, CurrentVersion, ev.Version)
+		}
+
+		if !reflect.DeepEqual(ev.Branches, test.branches) {
+			t.Errorf("expected %q to have branches %v, got %v", test.src, test.branches, ev.Branches)
+		}
+	}
+}
+
+func TestParsePipelineErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{
+			src: "name: test\nversion: 3\nsteps: []\n",
+			err: "line 2: pipeline version 3 isn't supported, the newest supported version is 2",
+		},
+		{
+			src: "version: -1\n",
+			err: "line 1: pipeline version -1 is invalid",
+		},
+		{
+			src: "version: two\n",
+			err: "line 1: cannot unmarshal !!str `two` into int",
+		},
+		{
+			src: "version: 2\nbranch: master\n",
+			err: "line 2: field branch not found in type runlet.Event",
+		},
+		{
+			src: "steps: []\nbogus: true\n",
+			err: "line 2: field bogus not found in type runlet.Event",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := ParsePipeline([]byte(test.src))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected %q to fail with %q, got %v", test.src, test.err, err)
+		}
+	}
+}
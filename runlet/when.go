@@ -0,0 +1,100 @@
+package runlet
+
+import (
+	"fmt"
+
+	"github.com/run-ci/git-poller/expr"
+)
+
+// ValidateWhen returns an error if the conditions of the pipeline or
+// any of its steps are malformed.
+func (ev Event) ValidateWhen() error {
+	if ev.When != "" {
+		_, err := expr.Parse(ev.When)
+		if err != nil {
+			return fmt.Errorf("invalid when: %v", err)
+		}
+	}
+
+	for _, step := range ev.Steps {
+		if step.When == "" {
+			continue
+		}
+
+		_, err := expr.Parse(step.When)
+		if err != nil {
+			return fmt.Errorf("step %v has an invalid when: %v", step.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyWhen evaluates the conditions of the pipeline and its steps in
+// env. It returns false if the pipeline shouldn't run, either because
+// its own condition doesn't hold or because none of its steps' do.
+// Otherwise it returns a copy of the event without the steps whose
+// conditions don't hold. Steps that are left out count as done for the
+// steps that need them.
+func (ev Event) ApplyWhen(env expr.Env) (Event, bool, error) {
+	ok, err := holds(ev.When, env)
+	if err != nil || !ok {
+		return ev, false, err
+	}
+
+	if len(ev.Steps) == 0 {
+		return ev, true, nil
+	}
+
+	dropped := map[string]bool{}
+	steps := []Step{}
+	for _, step := range ev.Steps {
+		ok, err := holds(step.When, env)
+		if err != nil {
+			return ev, false, err
+		}
+
+		if !ok {
+			dropped[step.Name] = true
+			continue
+		}
+
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return ev, false, nil
+	}
+
+	for i, step := range steps {
+		if len(step.Needs) == 0 {
+			continue
+		}
+
+		needs := []string{}
+		for _, need := range step.Needs {
+			if !dropped[need] {
+				needs = append(needs, need)
+			}
+		}
+
+		steps[i].Needs = needs
+	}
+
+	ev.Steps = steps
+	return ev, true, nil
+}
+
+// holds reports whether a condition holds. Empty conditions always do.
+func holds(when string, env expr.Env) (bool, error) {
+	if when == "" {
+		return true, nil
+	}
+
+	e, err := expr.Parse(when)
+	if err != nil {
+		return false, err
+	}
+
+	return e.Eval(env), nil
+}
@@ -0,0 +1,72 @@
+package runlet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/run-ci/git-poller/expr"
+)
+
+func TestApplyWhen(t *testing.T) {
+	ev := Event{
+		Steps: []Step{
+			{Name: "test"},
+			{Name: "deploy", When: `branch == "main"`},
+			{Name: "notify", Needs: []string{"test", "deploy"}},
+		},
+	}
+
+	applied, ok, err := ev.ApplyWhen(expr.Env{Branch: "main"})
+	if err != nil || !ok {
+		t.Fatalf("expected the pipeline to run on main, got %v, %v", ok, err)
+	}
+
+	if !reflect.DeepEqual(applied.Steps, ev.Steps) {
+		t.Fatalf("expected every step to run on main, got %+v", applied.Steps)
+	}
+
+	applied, ok, err = ev.ApplyWhen(expr.Env{Branch: "feature"})
+	if err != nil || !ok {
+		t.Fatalf("expected the pipeline to run on feature, got %v, %v", ok, err)
+	}
+
+	expected := []Step{
+		{Name: "test"},
+		{Name: "notify", Needs: []string{"test"}},
+	}
+	if !reflect.DeepEqual(applied.Steps, expected) {
+		t.Fatalf("expected steps %+v on feature, got %+v", expected, applied.Steps)
+	}
+
+	if len(ev.Steps[2].Needs) != 2 {
+		t.Fatal("expected the original event to be left alone")
+	}
+
+	ev.When = `tag =~ "v*"`
+	_, ok, err = ev.ApplyWhen(expr.Env{Branch: "main"})
+	if err != nil || ok {
+		t.Fatalf("expected the pipeline not to run without a tag, got %v, %v", ok, err)
+	}
+
+	// Pipelines that are left without steps don't run either.
+	ev = Event{Steps: []Step{{Name: "deploy", When: "false"}}}
+	_, ok, err = ev.ApplyWhen(expr.Env{})
+	if err != nil || ok {
+		t.Fatalf("expected a pipeline without steps left not to run, got %v, %v", ok, err)
+	}
+}
+
+func TestValidateWhen(t *testing.T) {
+	if err := (Event{When: `branch == "main"`}).ValidateWhen(); err != nil {
+		t.Errorf("expected a valid condition, got %v", err)
+	}
+
+	if err := (Event{When: `branch = "main"`}).ValidateWhen(); err == nil {
+		t.Error("expected a malformed pipeline condition to be invalid")
+	}
+
+	ev := Event{Steps: []Step{{Name: "deploy", When: `nope == "x"`}}}
+	if err := ev.ValidateWhen(); err == nil {
+		t.Error("expected a malformed step condition to be invalid")
+	}
+}
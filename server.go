@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 
 	"github.com/run-ci/git-poller/async"
 	"github.com/sirupsen/logrus"
@@ -16,6 +18,43 @@ type pollermsg struct {
 	Remote string `json:"remote"`
 	Branch string `json:"branch"`
 	Op     string `json:"op"`
+
+	// Mode is how new commits are turned into pipeline runs. It's one
+	// of "latest", "every" or "batched" and defaults to "latest".
+	Mode string `json:"mode"`
+
+	// Tags is a pattern of tags to watch instead of a branch, like
+	// "v*" or "refs/tags/v*".
+	Tags string `json:"tags"`
+
+	// Credential is the name of the credential in the credential store
+	// to access the remote with. Public remotes don't need one.
+	Credential string `json:"credential"`
+
+	// Interval is how often the poller checks the remote, like "30s"
+	// or "1h". It's kept within the service-wide bounds.
+	Interval string `json:"interval"`
+
+	// Adaptive pollers change their interval with the activity on the
+	// repo, between MinInterval and MaxInterval. The bounds default to
+	// the service-wide ones.
+	Adaptive    bool   `json:"adaptive"`
+	MinInterval string `json:"min_interval"`
+	MaxInterval string `json:"max_interval"`
+
+	// Pipelines is where pipelines are read from in the repo.
+	Pipelines discovery `json:"pipelines"`
+}
+
+// key is the key of the poller the message is for in the pool. Branch
+// pollers are keyed on "<remote>#<branch>" and tag pollers on
+// "<remote>#refs/tags/<pattern>".
+func (msg pollermsg) key() string {
+	if msg.Tags != "" {
+		return fmt.Sprintf("%v#%v%v", msg.Remote, tagRefPrefix, strings.TrimPrefix(msg.Tags, tagRefPrefix))
+	}
+
+	return fmt.Sprintf("%v#%v", msg.Remote, msg.Branch)
 }
 
 type handlerFunc func(pollermsg) error
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// source is somewhere the files of a repo can be read from. Paths are
+// slash-separated and relative to the root of the repo.
+type source interface {
+	ReadDir(path string) ([]os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+}
+
+// dirSource reads files from a directory on disk.
+type dirSource string
+
+func (root dirSource) ReadDir(p string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(string(root), filepath.FromSlash(p)))
+}
+
+func (root dirSource) ReadFile(p string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(root), filepath.FromSlash(p)))
+}
+
+// maxSymlinks is how many symlinks treeSource follows to get to a file
+// before giving up.
+const maxSymlinks = 8
+
+// treeSource reads files straight out of the tree of a commit, without
+// writing anything to disk. Paths that don't exist in the tree give
+// errors that satisfy os.IsNotExist, like they would on disk.
+type treeSource struct {
+	tree *object.Tree
+}
+
+// loadPipelinesAt loads the pipelines in any commit of a repo.
+func loadPipelinesAt(commit *object.Commit, d discovery) ([]pipeline, []pipelineError, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return loadPipelines(treeSource{tree: tree}, d)
+}
+
+func (ts treeSource) ReadDir(p string) ([]os.FileInfo, error) {
+	p = path.Clean(p)
+
+	tree := ts.tree
+	if p != "." {
+		var err error
+		tree, err = ts.tree.Tree(p)
+		if err == object.ErrDirectoryNotFound {
+			return nil, &os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: p, Err: err}
+		}
+	}
+
+	infos := make([]os.FileInfo, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		infos = append(infos, treeEntryInfo{entry})
+	}
+
+	return infos, nil
+}
+
+func (ts treeSource) ReadFile(p string) ([]byte, error) {
+	p = path.Clean(p)
+
+	for i := 0; i <= maxSymlinks; i++ {
+		f, err := ts.tree.File(p)
+		if err == object.ErrFileNotFound {
+			return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: p, Err: err}
+		}
+
+		contents, err := f.Contents()
+		if err != nil {
+			return nil, &os.PathError{Op: "read", Path: p, Err: err}
+		}
+
+		if f.Mode != filemode.Symlink {
+			return []byte(contents), nil
+		}
+
+		// Symlinks are followed as long as they stay inside the repo,
+		// since there's nothing outside of it to read.
+		target := contents
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+
+		if !insideRepo(target) {
+			return nil, &os.PathError{Op: "open", Path: p, Err: fmt.Errorf("symlink to %v leaves the repo", contents)}
+		}
+
+		p = path.Clean(target)
+	}
+
+	return nil, &os.PathError{Op: "open", Path: p, Err: fmt.Errorf("too many levels of symlinks")}
+}
+
+// treeEntryInfo describes an entry of a tree like a file on disk.
+type treeEntryInfo struct {
+	entry object.TreeEntry
+}
+
+func (info treeEntryInfo) Name() string {
+	return info.entry.Name
+}
+
+// Size is always 0, since finding out the real size means reading the
+// object.
+func (info treeEntryInfo) Size() int64 {
+	return 0
+}
+
+func (info treeEntryInfo) Mode() os.FileMode {
+	mode, err := info.entry.Mode.ToOSFileMode()
+	if err != nil {
+		return 0
+	}
+
+	return mode
+}
+
+func (info treeEntryInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (info treeEntryInfo) IsDir() bool {
+	return info.entry.Mode == filemode.Dir
+}
+
+func (info treeEntryInfo) Sys() interface{} {
+	return info.entry
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTreeSource(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml":        testPipeline,
+		"pipelines/deploy/prod.yaml": testPipeline,
+		"shared/lint.yaml":           "steps: []\n",
+	})
+
+	// Symlinks are read through, as long as they stay in the repo.
+	wt, err := tr.repo.Worktree()
+	if err != nil {
+		t.Fatalf("got error getting worktree: %v", err)
+	}
+
+	for name, target := range map[string]string{
+		"pipelines/lint.yaml":    "../shared/lint.yaml",
+		"pipelines/outside.yaml": "../../etc/passwd",
+	} {
+		err := os.Symlink(target, filepath.Join(tr.dir, name))
+		if err != nil {
+			t.Fatalf("got error creating symlink: %v", err)
+		}
+
+		_, err = wt.Add(name)
+		if err != nil {
+			t.Fatalf("got error adding %v: %v", name, err)
+		}
+	}
+
+	second := tr.commit("add symlinks", map[string]string{})
+
+	commit, err := tr.repo.CommitObject(second)
+	if err != nil {
+		t.Fatalf("got error getting commit: %v", err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatalf("got error getting tree: %v", err)
+	}
+	src := treeSource{tree: tree}
+
+	infos, err := src.ReadDir("pipelines")
+	if err != nil {
+		t.Fatalf("got error reading directory: %v", err)
+	}
+
+	names := []string{}
+	dirs := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+		if info.IsDir() {
+			dirs = append(dirs, info.Name())
+		}
+	}
+
+	expected := []string{"deploy", "lint.yaml", "outside.yaml", "test.yaml"}
+	if !reflect.DeepEqual(names, expected) || !reflect.DeepEqual(dirs, []string{"deploy"}) {
+		t.Fatalf("expected entries %v with deploy as a directory, got %v and %v", expected, names, dirs)
+	}
+
+	if infos, err := src.ReadDir("."); err != nil || len(infos) != 2 {
+		t.Fatalf("expected the root to have 2 entries, got %v, %v", len(infos), err)
+	}
+
+	buf, err := src.ReadFile("pipelines/lint.yaml")
+	if err != nil || string(buf) != "steps: []\n" {
+		t.Fatalf("expected the symlink to be followed, got %q, %v", buf, err)
+	}
+
+	_, err = src.ReadFile("pipelines/outside.yaml")
+	if err == nil || os.IsNotExist(err) {
+		t.Fatalf("expected a symlink out of the repo to fail, got %v", err)
+	}
+
+	_, err = src.ReadFile("pipelines/missing.yaml")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file not to exist, got %v", err)
+	}
+
+	_, err = src.ReadDir("missing")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a missing directory not to exist, got %v", err)
+	}
+
+	// Pipelines can be read at any commit, not just the latest.
+	old, err := tr.repo.CommitObject(first)
+	if err != nil {
+		t.Fatalf("got error getting commit: %v", err)
+	}
+
+	pipelines, errs, err := loadPipelinesAt(old, discovery{Recursive: true})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("expected pipelines to load, got %v, %v", errs, err)
+	}
+
+	if names := pipelineNames(pipelines); !reflect.DeepEqual(names, []string{"deploy/prod", "test"}) {
+		t.Fatalf("expected the pipelines of the first commit, got %v", names)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/run-ci/git-poller/glob"
+	"github.com/run-ci/git-poller/mirror"
+	"github.com/run-ci/git-poller/runlet"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const tagRefPrefix = "refs/tags/"
+
+// checkTags triggers pipelines for every tag matching the poller's
+// pattern that wasn't there the last time the remote was checked. Tags
+// that already exist when the poller first checks the remote are taken
+// as seen, so that starting a poller doesn't rerun every old release.
+func (gp *gitPoller) checkTags() error {
+	logger := logger.WithFields(logrus.Fields{
+		"poll":   "git",
+		"remote": gp.remote,
+		"tags":   gp.tags,
+	})
+
+	refs, err := gp.lsRemote()
+	if err != nil {
+		logger.WithError(err).Debug("unable to list remote refs")
+		return err
+	}
+
+	current := map[string]plumbing.Hash{}
+	for _, ref := range refs {
+		name := ref.Name().String()
+		if !strings.HasPrefix(name, tagRefPrefix) || strings.HasSuffix(name, "^{}") {
+			continue
+		}
+
+		tag := strings.TrimPrefix(name, tagRefPrefix)
+		if glob.Match(gp.tags, tag) {
+			current[tag] = ref.Hash()
+		}
+	}
+
+	if gp.seenTags == nil {
+		logger.Infof("found %v existing tags, waiting for new ones", len(current))
+
+		gp.seenTags = current
+		return nil
+	}
+
+	// Tags that were moved to another object count as new too.
+	newTags := []string{}
+	for tag, hash := range current {
+		if seen, ok := gp.seenTags[tag]; !ok || seen != hash {
+			newTags = append(newTags, tag)
+		}
+	}
+
+	// Tags that were deleted are forgotten, so they trigger again if
+	// they're ever pushed again.
+	for tag := range gp.seenTags {
+		if _, ok := current[tag]; !ok {
+			delete(gp.seenTags, tag)
+			gp.forgetDiagnostics(tagRefPrefix + tag)
+		}
+	}
+
+	if len(newTags) == 0 {
+		logger.Debug("no new tags, nothing to do")
+		return nil
+	}
+
+	gp.changes++
+
+	sort.Strings(newTags)
+
+	logger.Infof("found new tags %v, fetching into mirror", newTags)
+
+	m, err := gp.fetch()
+	if err != nil {
+		logger.WithError(err).Debug("unable to fetch repo")
+		return err
+	}
+	defer m.Release()
+
+	for _, tag := range newTags {
+		logger := logger.WithField("tag", tag)
+
+		t := trigger{tag: tag}
+
+		hash := current[tag]
+		t.commit, t.tagMessage, err = taggedCommit(m, hash)
+		if terr, ok := err.(*tagTargetError); ok {
+			// Tags on trees or blobs are never going to have pipelines,
+			// so they're taken as seen instead of failing every check.
+			logger.WithError(terr).Warn("tag doesn't point at a commit, skipping")
+
+			gp.publishDiagnostics(t, []runlet.Diagnostic{{Message: terr.Error()}})
+			gp.seenTags[tag] = hash
+			continue
+		}
+		if err != nil {
+			logger.WithError(err).Debug("unable to get tagged commit")
+			return err
+		}
+
+		t.commits = []*object.Commit{t.commit}
+
+		err = gp.publishPipelines(t)
+		if err != nil {
+			return err
+		}
+
+		gp.seenTags[tag] = hash
+	}
+
+	return nil
+}
+
+// tagTargetError is returned for tags that point at something other
+// than a commit.
+type tagTargetError struct {
+	typ plumbing.ObjectType
+}
+
+func (e *tagTargetError) Error() string {
+	return fmt.Sprintf("tag points at a %v, not a commit", e.typ)
+}
+
+// taggedCommit returns the commit the tag object or commit at hash
+// stands for, along with the message of annotated tags.
+func taggedCommit(m *mirror.Mirror, hash plumbing.Hash) (*object.Commit, string, error) {
+	// Annotated tags point to a tag object that holds the message,
+	// lightweight tags point straight to the commit.
+	obj, err := m.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch obj.Type() {
+	case plumbing.CommitObject:
+		commit, err := object.DecodeCommit(m.Storer, obj)
+		return commit, "", err
+	case plumbing.TagObject:
+		tagobj, err := object.DecodeTag(m.Storer, obj)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if tagobj.TargetType != plumbing.CommitObject {
+			return nil, "", &tagTargetError{typ: tagobj.TargetType}
+		}
+
+		commit, err := tagobj.Commit()
+		return commit, tagobj.Message, err
+	default:
+		return nil, "", &tagTargetError{typ: obj.Type()}
+	}
+}
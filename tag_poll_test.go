@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// tag tags the commit at hash. If msg is set, the tag is annotated.
+func (tr *testrepo) tag(name string, hash plumbing.Hash, msg string) {
+	var opts *git.CreateTagOptions
+	if msg != "" {
+		opts = &git.CreateTagOptions{
+			Tagger: &object.Signature{
+				Name:  "Test Tagger",
+				Email: "tagger@example.com",
+				When:  time.Now(),
+			},
+			Message: msg,
+		}
+	}
+
+	_, err := tr.repo.CreateTag(name, hash, opts)
+	if err != nil {
+		tr.t.Fatalf("got error creating tag %v: %v", name, err)
+	}
+}
+
+const testTagPipeline = `
+tags:
+- v*
+steps:
+- name: release
+  tasks:
+  - name: release
+`
+
+func TestCheckTags(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/test.yaml":    testPipeline,
+		"pipelines/release.yaml": testTagPipeline,
+	})
+	tr.tag("v0.1.0", first, "")
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		tags:   "v*",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected existing tags not to trigger, got %v events", len(evs))
+	}
+
+	second := tr.commit("second commit", map[string]string{"a": "a"})
+	tr.tag("v0.2.0", second, "Release 0.2.0")
+	tr.tag("other", second, "")
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event for new tag, got %v", len(evs))
+	}
+
+	ev := evs[0]
+	if ev.Name != "release" {
+		t.Fatalf("expected release pipeline to trigger, got %v", ev.Name)
+	}
+
+	if ev.Remote.Tag != "v0.2.0" {
+		t.Fatalf("expected event for tag v0.2.0, got %v", ev.Remote.Tag)
+	}
+
+	if ev.Remote.TagMessage != "Release 0.2.0\n" {
+		t.Fatalf("expected annotated tag message, got %q", ev.Remote.TagMessage)
+	}
+
+	if ev.Remote.Commits[0].SHA != second.String() {
+		t.Fatalf("expected event for tagged commit %v, got %v", second, ev.Remote.Commits[0].SHA)
+	}
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected seen tags not to trigger again, got %v events", len(evs))
+	}
+}
+
+func TestCheckTagsSkipsTagsOnTrees(t *testing.T) {
+	tr := newTestRepo(t)
+	defer tr.cleanup()
+
+	first := tr.commit("initial commit", map[string]string{
+		"pipelines/release.yaml": testTagPipeline,
+	})
+
+	mirrors, cleanup := newTestCache(t)
+	defer cleanup()
+
+	queue := make(chan []byte, 16)
+	gp := &gitPoller{
+		remote: tr.dir,
+		tags:   "v*",
+
+		mirrors: mirrors,
+		queue:   queue,
+	}
+
+	err := gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	commit, err := tr.repo.CommitObject(first)
+	if err != nil {
+		t.Fatalf("got error getting commit: %v", err)
+	}
+
+	tr.tag("v0-tree", commit.TreeHash, "")
+	tr.tag("v0-tree-annotated", commit.TreeHash, "A tree")
+	tr.tag("v1", first, "")
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	evs := drain(t, queue)
+	if len(evs) != 1 || evs[0].Remote.Tag != "v1" {
+		t.Fatalf("expected 1 event for tag v1, got %v", evs)
+	}
+
+	diags := gp.Diagnostics()
+	if len(diags) != 2 {
+		t.Fatalf("expected a diagnostic for each tag on a tree, got %v", diags)
+	}
+
+	for i, tag := range []string{"v0-tree", "v0-tree-annotated"} {
+		if diags[i].Tag != tag || diags[i].Message != "tag points at a tree, not a commit" {
+			t.Fatalf("expected diagnostic for tag %v, got %+v", tag, diags[i])
+		}
+	}
+
+	err = gp.checkRepo()
+	if err != nil {
+		t.Fatalf("got error checking repo: %v", err)
+	}
+
+	if evs := drain(t, queue); len(evs) != 0 {
+		t.Fatalf("expected seen tags not to trigger again, got %v events", len(evs))
+	}
+}
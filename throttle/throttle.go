@@ -0,0 +1,213 @@
+// Package throttle limits how many git network operations run at once,
+// both across the whole service and against each git host, so that lots
+// of pollers don't overwhelm the hosts they poll or hit rate limits.
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+var logger *logrus.Entry
+
+func init() {
+	logger = logrus.WithField("package", "throttle")
+}
+
+// HostLimit is how politely a git host is treated.
+type HostLimit struct {
+	// Concurrency is how many operations can run against the host at
+	// once. 0 or less means there's no limit.
+	Concurrency int
+	// Spacing is the least time between the start of one operation
+	// against the host and the next.
+	Spacing time.Duration
+}
+
+// Limiter limits git network operations. A nil Limiter doesn't limit
+// anything.
+type Limiter struct {
+	// global has a slot for every operation that can run at once, or
+	// is nil if there's no limit.
+	global chan struct{}
+
+	limits map[string]HostLimit
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// host is the state of the operations against one host.
+type host struct {
+	limit HostLimit
+	// slots is nil if the host's concurrency isn't limited.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// next is the earliest the next operation can start.
+	next time.Time
+}
+
+// New returns a Limiter that runs at most max operations at once, or
+// any number if max is 0 or less. Hosts that are in limits are limited
+// further. Hosts are matched on their name, like "github.com", and
+// ports are ignored.
+func New(max int, limits map[string]HostLimit) *Limiter {
+	l := &Limiter{
+		limits: limits,
+		hosts:  map[string]*host{},
+	}
+
+	if max > 0 {
+		l.global = make(chan struct{}, max)
+	}
+
+	return l
+}
+
+// Do runs fn, an operation against remote, once the limits allow it.
+func (l *Limiter) Do(remote string, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+
+	name := Host(remote)
+	logger := logger.WithFields(logrus.Fields{
+		"remote": remote,
+		"host":   name,
+	})
+
+	start := time.Now()
+
+	// The host's limits are waited on first, so that operations stuck
+	// behind a busy host don't hold on to global slots that operations
+	// against other hosts could use.
+	h := l.host(name)
+	if h != nil {
+		if h.slots != nil {
+			h.slots <- struct{}{}
+			defer func() { <-h.slots }()
+		}
+
+		h.wait()
+	}
+
+	if l.global != nil {
+		l.global <- struct{}{}
+		defer func() { <-l.global }()
+	}
+
+	if waited := time.Since(start); waited > time.Second {
+		logger.Debugf("waited %v for git operation", waited)
+	}
+
+	return fn()
+}
+
+// host returns the state of the named host, or nil if it isn't limited.
+func (l *Limiter) host(name string) *host {
+	limit, ok := l.limits[name]
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h, ok := l.hosts[name]
+	if !ok {
+		h = &host{limit: limit}
+		if limit.Concurrency > 0 {
+			h.slots = make(chan struct{}, limit.Concurrency)
+		}
+
+		l.hosts[name] = h
+	}
+
+	return h
+}
+
+// wait blocks until the host's spacing allows another operation to
+// start.
+func (h *host) wait() {
+	if h.limit.Spacing <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(h.limit.Spacing)
+	h.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
+
+// Host returns the name of the host a remote is on, or "" for remotes
+// on local disk.
+func Host(remote string) string {
+	ep, err := transport.NewEndpoint(remote)
+	if err != nil {
+		return ""
+	}
+
+	return ep.Host
+}
+
+// ParseHostLimits parses host limits written like
+// "github.com=4/1s,gitlab.com=2,example.com=/500ms", where the number
+// after the host is its concurrency and the duration after the slash
+// is its spacing. Either can be left out.
+func ParseHostLimits(s string) (map[string]HostLimit, error) {
+	limits := map[string]HostLimit{}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		tup := strings.SplitN(entry, "=", 2)
+		if len(tup) != 2 || tup[0] == "" {
+			return nil, fmt.Errorf("invalid host limit %v, expected host=concurrency/spacing", entry)
+		}
+
+		var limit HostLimit
+		raw := strings.SplitN(tup[1], "/", 2)
+
+		if raw[0] != "" {
+			n, err := strconv.Atoi(raw[0])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid concurrency %v for host %v", raw[0], tup[0])
+			}
+
+			limit.Concurrency = n
+		}
+
+		if len(raw) == 2 {
+			d, err := time.ParseDuration(raw[1])
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid spacing %v for host %v", raw[1], tup[0])
+			}
+
+			limit.Spacing = d
+		}
+
+		if _, ok := limits[tup[0]]; ok {
+			return nil, fmt.Errorf("host %v is limited more than once", tup[0])
+		}
+
+		limits[tup[0]] = limit
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,149 @@
+package throttle
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrency runs n operations against remote through l at the same
+// time and returns the most that ran at once.
+func concurrency(l *Limiter, remote string, n int) int {
+	var mu sync.Mutex
+	running, most := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_ = l.Do(remote, func() error {
+				mu.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	return most
+}
+
+func TestLimiterGlobal(t *testing.T) {
+	l := New(3, nil)
+
+	if most := concurrency(l, "https://github.com/run-ci/git-poller.git", 12); most > 3 {
+		t.Fatalf("expected at most 3 operations at once, got %v", most)
+	}
+
+	var unlimited *Limiter
+	if most := concurrency(unlimited, "https://github.com/run-ci/git-poller.git", 4); most != 4 {
+		t.Fatalf("expected a nil limiter not to limit anything, got %v at once", most)
+	}
+}
+
+func TestLimiterHost(t *testing.T) {
+	l := New(0, map[string]HostLimit{
+		"github.com": {Concurrency: 2},
+	})
+
+	if most := concurrency(l, "git@github.com:run-ci/git-poller.git", 8); most > 2 {
+		t.Fatalf("expected at most 2 operations against github.com at once, got %v", most)
+	}
+
+	if most := concurrency(l, "https://gitlab.com/run-ci/git-poller.git", 4); most != 4 {
+		t.Fatalf("expected other hosts not to be limited, got %v at once", most)
+	}
+}
+
+func TestLimiterSpacing(t *testing.T) {
+	l := New(0, map[string]HostLimit{
+		"github.com": {Spacing: 20 * time.Millisecond},
+	})
+
+	var mu sync.Mutex
+	starts := []time.Time{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_ = l.Do("https://github.com/run-ci/git-poller.git", func() error {
+				mu.Lock()
+				starts = append(starts, time.Now())
+				mu.Unlock()
+
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	// The starts were recorded in order, since each one is spaced out
+	// after the one before.
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 15*time.Millisecond {
+			t.Fatalf("expected operations to be spaced out, got a gap of %v", gap)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/run-ci/git-poller.git":     "github.com",
+		"https://github.com:443/run-ci/git-poller.git": "github.com",
+		"ssh://git@gitlab.com/run-ci/git-poller.git":   "gitlab.com",
+		"git@github.com:run-ci/git-poller.git":         "github.com",
+		"/srv/git/git-poller.git":                      "",
+		"file:///srv/git/git-poller.git":               "",
+	}
+
+	for remote, expected := range tests {
+		if host := Host(remote); host != expected {
+			t.Fatalf("expected %v to be on %q, got %q", remote, expected, host)
+		}
+	}
+}
+
+func TestParseHostLimits(t *testing.T) {
+	limits, err := ParseHostLimits("github.com=4/1s, gitlab.com=2,example.com=/500ms")
+	if err != nil {
+		t.Fatalf("got error parsing host limits: %v", err)
+	}
+
+	expected := map[string]HostLimit{
+		"github.com":  {Concurrency: 4, Spacing: time.Second},
+		"gitlab.com":  {Concurrency: 2},
+		"example.com": {Spacing: 500 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Fatalf("expected %v, got %v", expected, limits)
+	}
+
+	limits, err = ParseHostLimits("")
+	if err != nil || len(limits) != 0 {
+		t.Fatalf("expected no limits, got %v, %v", limits, err)
+	}
+
+	for _, s := range []string{"github.com", "=4", "github.com=0", "github.com=four", "github.com=4/soon", "github.com=1,github.com=2"} {
+		_, err := ParseHostLimits(s)
+		if err == nil {
+			t.Fatalf("expected %q to be invalid", s)
+		}
+	}
+}